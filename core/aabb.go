@@ -0,0 +1,80 @@
+package core
+
+import "math"
+
+// AABB is an axis aligned bounding box
+type AABB struct {
+	Min Vector3
+	Max Vector3
+}
+
+// EmptyAABB returns a box that contains nothing, so that growing it by any point or box gives
+// back that point or box
+func EmptyAABB() AABB {
+	inf := math.Inf(1)
+	return AABB{Vector3{inf, inf, inf}, Vector3{-inf, -inf, -inf}}
+}
+
+// Union returns the smallest box containing both a and b
+func (a AABB) Union(b AABB) AABB {
+	return AABB{
+		Vector3{math.Min(a.Min.X, b.Min.X), math.Min(a.Min.Y, b.Min.Y), math.Min(a.Min.Z, b.Min.Z)},
+		Vector3{math.Max(a.Max.X, b.Max.X), math.Max(a.Max.Y, b.Max.Y), math.Max(a.Max.Z, b.Max.Z)},
+	}
+}
+
+// AddPoint returns the smallest box containing both a and p
+func (a AABB) AddPoint(p Vector3) AABB {
+	return a.Union(AABB{p, p})
+}
+
+// Centre returns the point in the middle of the box
+func (a AABB) Centre() Vector3 {
+	return a.Min.Add(a.Max).Smult(0.5)
+}
+
+// SurfaceArea returns the surface area of the box, or 0 if it is empty
+func (a AABB) SurfaceArea() float64 {
+	e := a.Max.Subtract(a.Min)
+	if e.X < 0 || e.Y < 0 || e.Z < 0 {
+		return 0
+	}
+
+	return 2 * (e.X*e.Y + e.Y*e.Z + e.Z*e.X)
+}
+
+// IntersectWithRay uses the slab method to find where the ray s + λd enters and leaves the box.
+// invD is the component wise inverse of d so it can be shared between boxes
+func (a AABB) IntersectWithRay(s, invD Vector3, tMin, tMax float64) (float64, float64, bool) {
+	var ok bool
+	if tMin, tMax, ok = slab(a.Min.X, a.Max.X, s.X, invD.X, tMin, tMax); !ok {
+		return 0, 0, false
+	}
+	if tMin, tMax, ok = slab(a.Min.Y, a.Max.Y, s.Y, invD.Y, tMin, tMax); !ok {
+		return 0, 0, false
+	}
+	if tMin, tMax, ok = slab(a.Min.Z, a.Max.Z, s.Z, invD.Z, tMin, tMax); !ok {
+		return 0, 0, false
+	}
+
+	return tMin, tMax, true
+}
+
+// slab narrows [tMin, tMax] to the part of the ray between the planes min and max on one axis
+func slab(min, max, s, invD, tMin, tMax float64) (float64, float64, bool) {
+	t0 := (min - s) * invD
+	t1 := (max - s) * invD
+	if invD < 0 {
+		t0, t1 = t1, t0
+	}
+
+	// NaN from 0 * inf is ignored by only narrowing when the comparison holds
+	if t0 > tMin {
+		tMin = t0
+	}
+	if t1 < tMax {
+		tMax = t1
+	}
+
+	return tMin, tMax, tMin <= tMax
+}
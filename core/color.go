@@ -0,0 +1,6 @@
+package core
+
+// Luminance returns the brightness of the linear RGB colour c as seen by the eye
+func Luminance(c Vector3) float64 {
+	return 0.2126*c.X + 0.7152*c.Y + 0.0722*c.Z
+}
@@ -0,0 +1,403 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sort"
+)
+
+// EXRPixelType is how the channels of an OpenEXR file are stored
+type EXRPixelType int32
+
+const (
+	// EXRHalf stores 16 bit floats, enough for most images at half the size
+	EXRHalf EXRPixelType = 1
+	// EXRFloat stores 32 bit floats
+	EXRFloat EXRPixelType = 2
+)
+
+// EXRCompression is how the pixels of an OpenEXR file are compressed
+type EXRCompression uint8
+
+const (
+	// EXRNone stores the pixels as they are
+	EXRNone EXRCompression = 0
+	// EXRZIPS compresses each scanline with zlib
+	EXRZIPS EXRCompression = 2
+	// EXRZIP compresses blocks of 16 scanlines with zlib
+	EXRZIP EXRCompression = 3
+)
+
+// linesPerChunk returns the number of scanlines stored together
+func (c EXRCompression) linesPerChunk() int {
+	if c == EXRZIP {
+		return 16
+	}
+	return 1
+}
+
+// ParseEXRPixelType returns the EXRPixelType called name, half or float
+func ParseEXRPixelType(name string) (EXRPixelType, error) {
+	switch name {
+	case "half":
+		return EXRHalf, nil
+	case "float":
+		return EXRFloat, nil
+	}
+	return 0, fmt.Errorf("unknown EXR pixel type %q, want half or float", name)
+}
+
+// ParseEXRCompression returns the EXRCompression called name, none or zip
+func ParseEXRCompression(name string) (EXRCompression, error) {
+	switch name {
+	case "none":
+		return EXRNone, nil
+	case "zip":
+		return EXRZIP, nil
+	}
+	return 0, fmt.Errorf("unknown EXR compression %q, want none or zip", name)
+}
+
+// exrChannels are the channels written, which must be in alphabetical order
+var exrChannels = []string{"B", "G", "R"}
+
+// channel returns the value of the named channel of c
+func channel(c Vector3, name string) float64 {
+	switch name {
+	case "R":
+		return c.X
+	case "G":
+		return c.Y
+	}
+	return c.Z
+}
+
+// WriteEXR writes the framebuffer to w as a scanline OpenEXR file with linear R, G and B
+// channels
+func WriteEXR(w io.Writer, fb *Framebuffer, pixelType EXRPixelType, compression EXRCompression) error {
+	var header bytes.Buffer
+	le := binary.LittleEndian
+
+	attribute := func(name, typ string, value []byte) {
+		header.WriteString(name + "\x00" + typ + "\x00")
+		binary.Write(&header, le, int32(len(value)))
+		header.Write(value)
+	}
+	values := func(v ...interface{}) []byte {
+		var b bytes.Buffer
+		for _, x := range v {
+			binary.Write(&b, le, x)
+		}
+		return b.Bytes()
+	}
+
+	var chlist bytes.Buffer
+	for _, name := range exrChannels {
+		chlist.WriteString(name + "\x00")
+		binary.Write(&chlist, le, int32(pixelType))
+		// pLinear and reserved bytes, then the x and y sampling
+		chlist.Write([]byte{0, 0, 0, 0})
+		binary.Write(&chlist, le, []int32{1, 1})
+	}
+	chlist.WriteByte(0)
+
+	window := values(int32(0), int32(0), int32(fb.Width-1), int32(fb.Height-1))
+
+	header.Write([]byte{0x76, 0x2f, 0x31, 0x01})
+	binary.Write(&header, le, int32(2))
+	attribute("channels", "chlist", chlist.Bytes())
+	attribute("compression", "compression", []byte{byte(compression)})
+	attribute("dataWindow", "box2i", window)
+	attribute("displayWindow", "box2i", window)
+	attribute("lineOrder", "lineOrder", []byte{0})
+	attribute("pixelAspectRatio", "float", values(float32(1)))
+	attribute("screenWindowCenter", "v2f", values(float32(0), float32(0)))
+	attribute("screenWindowWidth", "float", values(float32(1)))
+	header.WriteByte(0)
+
+	// Build every chunk first so the offset table can be filled in
+	lines := compression.linesPerChunk()
+	var chunks [][]byte
+	for y0 := 0; y0 < fb.Height; y0 += lines {
+		y1 := y0 + lines
+		if y1 > fb.Height {
+			y1 = fb.Height
+		}
+
+		var raw bytes.Buffer
+		for y := y0; y < y1; y++ {
+			for _, name := range exrChannels {
+				for x := 0; x < fb.Width; x++ {
+					v := float32(channel(fb.GetPixel(x, y), name))
+					if pixelType == EXRHalf {
+						binary.Write(&raw, le, floatToHalf(v))
+					} else {
+						binary.Write(&raw, le, math.Float32bits(v))
+					}
+				}
+			}
+		}
+
+		data := raw.Bytes()
+		if compression != EXRNone {
+			// Data that doesn't shrink is stored as it is, which readers tell from its size
+			if packed, err := zipCompress(data); err != nil {
+				return err
+			} else if len(packed) < len(data) {
+				data = packed
+			}
+		}
+
+		var chunk bytes.Buffer
+		binary.Write(&chunk, le, int32(y0))
+		binary.Write(&chunk, le, int32(len(data)))
+		chunk.Write(data)
+		chunks = append(chunks, chunk.Bytes())
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(header.Bytes()); err != nil {
+		return err
+	}
+
+	offset := uint64(header.Len() + 8*len(chunks))
+	for _, c := range chunks {
+		if err := binary.Write(bw, le, offset); err != nil {
+			return err
+		}
+		offset += uint64(len(c))
+	}
+	for _, c := range chunks {
+		if _, err := bw.Write(c); err != nil {
+			return err
+		}
+	}
+
+	return bw.Flush()
+}
+
+// zipCompress applies the predictor and byte interleaving OpenEXR uses before deflating data
+func zipCompress(data []byte) ([]byte, error) {
+	n := len(data)
+	t := make([]byte, n)
+
+	// Put the even bytes in the first half and the odd ones in the second
+	half := (n + 1) / 2
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			t[i/2] = data[i]
+		} else {
+			t[half+i/2] = data[i]
+		}
+	}
+
+	// Store differences, working backwards so each uses the original previous byte
+	for i := n - 1; i > 0; i-- {
+		t[i] = byte(int(t[i]) - int(t[i-1]) + 128)
+	}
+
+	var b bytes.Buffer
+	zw := zlib.NewWriter(&b)
+	if _, err := zw.Write(t); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// zipDecompress undoes zipCompress, giving n bytes
+func zipDecompress(data []byte, n int) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	t := make([]byte, n)
+	if _, err := io.ReadFull(zr, t); err != nil {
+		return nil, err
+	}
+
+	for i := 1; i < n; i++ {
+		t[i] = byte(int(t[i-1]) + int(t[i]) - 128)
+	}
+
+	out := make([]byte, n)
+	half := (n + 1) / 2
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			out[i] = t[i/2]
+		} else {
+			out[i] = t[half+i/2]
+		}
+	}
+	return out, nil
+}
+
+// exrChannel is a channel from the header of an OpenEXR file
+type exrChannel struct {
+	name      string
+	pixelType EXRPixelType
+}
+
+// ReadEXR reads a scanline OpenEXR file with R, G and B half or float channels, stored
+// uncompressed or with ZIP or ZIPS compression. Missing channels are read as 0
+func ReadEXR(r io.Reader) (*Framebuffer, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	le := binary.LittleEndian
+	bad := errors.New("exr: malformed file")
+
+	if len(data) < 8 || !bytes.Equal(data[:4], []byte{0x76, 0x2f, 0x31, 0x01}) {
+		return nil, errors.New("exr: not an OpenEXR file")
+	}
+	if flags := le.Uint32(data[4:]) &^ 0xff; flags&^0x400 != 0 {
+		return nil, errors.New("exr: only single part scanline files are supported")
+	}
+
+	pos := 8
+	cstring := func() (string, bool) {
+		end := bytes.IndexByte(data[pos:], 0)
+		if end < 0 {
+			return "", false
+		}
+		s := string(data[pos : pos+end])
+		pos += end + 1
+		return s, true
+	}
+
+	var channels []exrChannel
+	compression := EXRNone
+	var xMin, yMin, xMax, yMax int32
+	haveWindow := false
+	for {
+		name, ok := cstring()
+		if !ok {
+			return nil, bad
+		}
+		if name == "" {
+			break
+		}
+		typ, ok := cstring()
+		if !ok || pos+4 > len(data) {
+			return nil, bad
+		}
+		size := int(le.Uint32(data[pos:]))
+		pos += 4
+		if size < 0 || pos+size > len(data) {
+			return nil, bad
+		}
+		value := data[pos : pos+size]
+		pos += size
+
+		switch {
+		case name == "channels" && typ == "chlist":
+			for len(value) > 1 {
+				end := bytes.IndexByte(value, 0)
+				if end < 0 || len(value) < end+17 {
+					return nil, bad
+				}
+				c := exrChannel{string(value[:end]), EXRPixelType(le.Uint32(value[end+1:]))}
+				if c.pixelType != EXRHalf && c.pixelType != EXRFloat {
+					return nil, fmt.Errorf("exr: channel %q has an unsupported pixel type", c.name)
+				}
+				if le.Uint32(value[end+9:]) != 1 || le.Uint32(value[end+13:]) != 1 {
+					return nil, fmt.Errorf("exr: channel %q is subsampled", c.name)
+				}
+				channels = append(channels, c)
+				value = value[end+17:]
+			}
+		case name == "compression" && size == 1:
+			compression = EXRCompression(value[0])
+			if compression != EXRNone && compression != EXRZIPS && compression != EXRZIP {
+				return nil, fmt.Errorf("exr: unsupported compression %d", compression)
+			}
+		case name == "dataWindow" && size == 16:
+			xMin, yMin = int32(le.Uint32(value)), int32(le.Uint32(value[4:]))
+			xMax, yMax = int32(le.Uint32(value[8:])), int32(le.Uint32(value[12:]))
+			haveWindow = true
+		}
+	}
+	if !haveWindow || len(channels) == 0 || xMax < xMin || yMax < yMin {
+		return nil, bad
+	}
+
+	// Channels are stored in name order
+	sort.Slice(channels, func(i, j int) bool { return channels[i].name < channels[j].name })
+
+	width, height := int(xMax-xMin+1), int(yMax-yMin+1)
+	lineSize := 0
+	for _, c := range channels {
+		lineSize += width * 2 * int(c.pixelType)
+	}
+
+	fb := NewFramebuffer(width, height)
+	lines := compression.linesPerChunk()
+	chunks := (height + lines - 1) / lines
+	if pos+8*chunks > len(data) {
+		return nil, bad
+	}
+
+	for i := 0; i < chunks; i++ {
+		offset := int(le.Uint64(data[pos+8*i:]))
+		if offset < 0 || offset+8 > len(data) {
+			return nil, bad
+		}
+		y0 := int(int32(le.Uint32(data[offset:]))) - int(yMin)
+		size := int(le.Uint32(data[offset+4:]))
+		if y0 < 0 || y0 >= height || offset+8+size > len(data) {
+			return nil, bad
+		}
+
+		n := lines
+		if y0+n > height {
+			n = height - y0
+		}
+		block := data[offset+8 : offset+8+size]
+		if size != n*lineSize {
+			if compression == EXRNone {
+				return nil, bad
+			}
+			if block, err = zipDecompress(block, n*lineSize); err != nil {
+				return nil, fmt.Errorf("exr: %v", err)
+			}
+		}
+
+		for y := y0; y < y0+n; y++ {
+			for _, c := range channels {
+				for x := 0; x < width; x++ {
+					var v float64
+					if c.pixelType == EXRHalf {
+						v = float64(halfToFloat(le.Uint16(block)))
+						block = block[2:]
+					} else {
+						v = float64(math.Float32frombits(le.Uint32(block)))
+						block = block[4:]
+					}
+
+					p := fb.GetPixel(x, y)
+					switch c.name {
+					case "R":
+						p.X = v
+					case "G":
+						p.Y = v
+					case "B":
+						p.Z = v
+					}
+					fb.SetPixel(x, y, p)
+				}
+			}
+		}
+	}
+
+	return fb, nil
+}
@@ -0,0 +1,121 @@
+package core
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// exrTestImage returns a width x height image with values over the range half floats can hold,
+// including negatives, zeros and subnormals
+func exrTestImage(width, height int, rng *rand.Rand) *Framebuffer {
+	fb := NewFramebuffer(width, height)
+	value := func() float64 {
+		switch rng.Intn(8) {
+		case 0:
+			return 0
+		case 1:
+			return -rng.Float64()
+		case 2:
+			return 1e-6 * rng.Float64()
+		}
+		return math.Ldexp(rng.Float64(), rng.Intn(30)-14)
+	}
+	for i := range fb.Pix {
+		fb.Pix[i] = Vector3{X: value(), Y: value(), Z: value()}
+	}
+	return fb
+}
+
+// exrTolerance returns how far a channel of value x can move being stored as pixelType
+func exrTolerance(x float64, pixelType EXRPixelType) float64 {
+	if pixelType == EXRHalf {
+		// Half of the 10 bit mantissa step, which stops shrinking below the smallest normal
+		return math.Max(math.Abs(x), math.Ldexp(1, -14)) * math.Ldexp(1, -11)
+	}
+	return math.Abs(x) * math.Ldexp(1, -24)
+}
+
+func TestEXRRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// 37 rows don't fill the last 16 line ZIP chunk
+	fb := exrTestImage(53, 37, rng)
+
+	for _, pixelType := range []EXRPixelType{EXRHalf, EXRFloat} {
+		for _, compression := range []EXRCompression{EXRNone, EXRZIPS, EXRZIP} {
+			var buf bytes.Buffer
+			if err := WriteEXR(&buf, fb, pixelType, compression); err != nil {
+				t.Fatalf("%v, %v: %v", pixelType, compression, err)
+			}
+			got, err := ReadEXR(&buf)
+			if err != nil {
+				t.Fatalf("%v, %v: %v", pixelType, compression, err)
+			}
+
+			if got.Width != fb.Width || got.Height != fb.Height {
+				t.Fatalf("%v, %v: read back a %dx%d image, want %dx%d", pixelType, compression, got.Width, got.Height, fb.Width, fb.Height)
+			}
+			for i, c := range fb.Pix {
+				g := got.Pix[i]
+				for _, ch := range [][2]float64{{g.X, c.X}, {g.Y, c.Y}, {g.Z, c.Z}} {
+					if tol := exrTolerance(ch[1], pixelType); math.Abs(ch[0]-ch[1]) > tol {
+						t.Fatalf("%v, %v: pixel %d is %v, want %v within %v", pixelType, compression, i, g, c, tol)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEXRZIPCompresses(t *testing.T) {
+	fb := NewFramebuffer(200, 100)
+	for i := range fb.Pix {
+		fb.Pix[i] = Vector3{X: 0.5, Y: 0.25, Z: 2}
+	}
+
+	var plain, zipped bytes.Buffer
+	if err := WriteEXR(&plain, fb, EXRHalf, EXRNone); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteEXR(&zipped, fb, EXRHalf, EXRZIP); err != nil {
+		t.Fatal(err)
+	}
+	if zipped.Len() >= plain.Len()/10 {
+		t.Errorf("ZIP file of a flat image is %d bytes, uncompressed it is %d", zipped.Len(), plain.Len())
+	}
+}
+
+func TestHalfConversion(t *testing.T) {
+	for _, tc := range []struct {
+		f    float32
+		half uint16
+	}{
+		{0, 0x0000},
+		{1, 0x3c00},
+		{-2, 0xc000},
+		{65504, 0x7bff},
+		{1e6, 0x7c00},
+		{float32(math.Ldexp(1, -14)), 0x0400},
+		{float32(math.Ldexp(1, -24)), 0x0001},
+		{float32(math.Ldexp(1, -26)), 0x0000},
+		// Halfway between 1 and the next half rounds to even
+		{float32(1 + math.Ldexp(1, -11)), 0x3c00},
+		{float32(1 + 3*math.Ldexp(1, -11)), 0x3c02},
+	} {
+		if got := floatToHalf(tc.f); got != tc.half {
+			t.Errorf("floatToHalf(%v) = %#04x, want %#04x", tc.f, got, tc.half)
+		}
+	}
+
+	// Every finite half survives the trip through float32
+	for h := 0; h < 0x10000; h++ {
+		if h&0x7c00 == 0x7c00 {
+			continue
+		}
+		if got := floatToHalf(halfToFloat(uint16(h))); got != uint16(h) {
+			t.Errorf("half %#04x came back as %#04x", h, got)
+		}
+	}
+}
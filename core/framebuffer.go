@@ -0,0 +1,47 @@
+package core
+
+import (
+	"image/color"
+	"math"
+)
+
+// Framebuffer holds the linear RGB radiance of each pixel of a render, unbounded and unaffected
+// by how it will be displayed
+type Framebuffer struct {
+	Width  int
+	Height int
+	// Pix holds the pixels a row at a time from the top left
+	Pix []Vector3
+}
+
+// NewFramebuffer creates a black framebuffer
+func NewFramebuffer(width, height int) *Framebuffer {
+	return &Framebuffer{width, height, make([]Vector3, width*height)}
+}
+
+// SetPixel sets the radiance at (x, y) to c
+func (fb *Framebuffer) SetPixel(x, y int, c Vector3) {
+	fb.Pix[y*fb.Width+x] = c
+}
+
+// GetPixel returns the radiance at (x, y)
+func (fb *Framebuffer) GetPixel(x, y int) Vector3 {
+	return fb.Pix[y*fb.Width+x]
+}
+
+// ToImage converts the framebuffer to 8 bit sRGB for display, see Display
+func (fb *Framebuffer) ToImage(d Display) *Image {
+	img := NewImage(fb.Width, fb.Height)
+	for y := 0; y < fb.Height; y++ {
+		for x := 0; x < fb.Width; x++ {
+			c := d.Apply(fb.GetPixel(x, y))
+			img.SetPixel(x, y, color.RGBA{to8Bit(c.X), to8Bit(c.Y), to8Bit(c.Z), 0xff})
+		}
+	}
+	return img
+}
+
+// to8Bit rounds x in [0, 1] to a byte
+func to8Bit(x float64) uint8 {
+	return uint8(math.Max(0, math.Min(1, x))*255 + 0.5)
+}
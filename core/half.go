@@ -0,0 +1,67 @@
+package core
+
+import "math"
+
+// floatToHalf converts f to an IEEE 754 half precision float, rounding to the nearest value
+// and saturating to infinity
+func floatToHalf(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exp := int32(bits>>23) & 0xff
+	mant := bits & 0x7fffff
+
+	switch {
+	case exp == 0xff:
+		// Infinity or NaN, keeping NaN a NaN
+		if mant != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exp-127 > 15:
+		return sign | 0x7c00
+	case exp-127 >= -14:
+		// Normal, round the mantissa to 10 bits to nearest even
+		half := uint32(exp-127+15)<<10 | mant>>13
+		rest := mant & 0x1fff
+		if rest > 0x1000 || (rest == 0x1000 && half&1 == 1) {
+			half++
+		}
+		return sign | uint16(half)
+	case exp-127 >= -25:
+		// Subnormal, shift the mantissa with its implicit 1 into place
+		mant |= 0x800000
+		shift := uint32(-exp + 127 - 14 + 13)
+		half := mant >> shift
+		rest := mant & (1<<shift - 1)
+		mid := uint32(1) << (shift - 1)
+		if rest > mid || (rest == mid && half&1 == 1) {
+			half++
+		}
+		return sign | uint16(half)
+	}
+	return sign
+}
+
+// halfToFloat converts the half precision float h to a float32
+func halfToFloat(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exp := uint32(h>>10) & 0x1f
+	mant := uint32(h & 0x3ff)
+
+	switch exp {
+	case 0:
+		if mant == 0 {
+			return math.Float32frombits(sign)
+		}
+		// Subnormal, normalise it
+		e := uint32(127 - 14)
+		for mant&0x400 == 0 {
+			mant <<= 1
+			e--
+		}
+		return math.Float32frombits(sign | e<<23 | (mant&0x3ff)<<13)
+	case 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mant<<13)
+	}
+	return math.Float32frombits(sign | (exp-15+127)<<23 | mant<<13)
+}
@@ -4,6 +4,7 @@ import (
 	"image"
 	"image/color"
 	"image/png"
+	"io"
 	"os"
 )
 
@@ -34,15 +35,24 @@ func (i *Image) GetPixel(x, y int) color.RGBA {
 	return i.Img.RGBAAt(x, y)
 }
 
-// PrintToFile outputs the contents oof the Image to the file with name fname
+// PrintToFile outputs the contents of the Image to the file with name fname as a PNG
 func (i *Image) PrintToFile(fname string) error {
-	f, err := os.Create(fname)
-	defer f.Close()
+	return WriteFile(fname, func(w io.Writer) error {
+		return png.Encode(w, i.Img)
+	})
+}
 
+// WriteFile creates the file fname and fills it with write, returning the first error from
+// creating, writing or closing it
+func WriteFile(fname string, write func(w io.Writer) error) error {
+	f, err := os.Create(fname)
 	if err != nil {
 		return err
 	}
 
-	png.Encode(f, i.Img)
-	return nil
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
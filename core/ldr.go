@@ -0,0 +1,90 @@
+package core
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// ToImage16 converts the framebuffer to 16 bit sRGB for display, keeping more of the precision
+// of the radiance than ToImage
+func (fb *Framebuffer) ToImage16(d Display) *image.RGBA64 {
+	img := image.NewRGBA64(image.Rect(0, 0, fb.Width, fb.Height))
+	to16Bit := func(x float64) uint16 {
+		return uint16(math.Max(0, math.Min(1, x))*0xffff + 0.5)
+	}
+
+	for y := 0; y < fb.Height; y++ {
+		for x := 0; x < fb.Width; x++ {
+			c := d.Apply(fb.GetPixel(x, y))
+			img.SetRGBA64(x, y, color.RGBA64{to16Bit(c.X), to16Bit(c.Y), to16Bit(c.Z), 0xffff})
+		}
+	}
+	return img
+}
+
+// WritePPM writes the image to w as a binary PPM
+func (i *Image) WritePPM(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "P6\n%d %d\n255\n", i.Width, i.Height)
+
+	for y := 0; y < i.Height; y++ {
+		for x := 0; x < i.Width; x++ {
+			c := i.GetPixel(x, y)
+			bw.Write([]byte{c.R, c.G, c.B})
+		}
+	}
+	return bw.Flush()
+}
+
+// WriteBMP writes the image to w as an uncompressed 24 bit BMP
+func (i *Image) WriteBMP(w io.Writer) error {
+	// Rows are padded to a multiple of 4 bytes
+	stride := (3*i.Width + 3) &^ 3
+	const headerSize = 14 + 40
+	size := headerSize + stride*i.Height
+
+	bw := bufio.NewWriter(w)
+	le := binary.LittleEndian
+
+	// File header then BITMAPINFOHEADER
+	bw.WriteString("BM")
+	binary.Write(bw, le, []uint32{uint32(size), 0, headerSize})
+	binary.Write(bw, le, []int32{40, int32(i.Width), int32(i.Height)})
+	binary.Write(bw, le, []uint16{1, 24})
+	// No compression, the image size, 72 DPI and no palette
+	binary.Write(bw, le, []uint32{0, uint32(stride * i.Height), 2835, 2835, 0, 0})
+
+	row := make([]byte, stride)
+	// Rows are stored from the bottom up, with the colours as BGR
+	for y := i.Height - 1; y >= 0; y-- {
+		for x := 0; x < i.Width; x++ {
+			c := i.GetPixel(x, y)
+			row[3*x], row[3*x+1], row[3*x+2] = c.B, c.G, c.R
+		}
+		bw.Write(row)
+	}
+	return bw.Flush()
+}
+
+// WritePFM writes the linear radiance in the framebuffer to w as a colour Portable Float Map
+func WritePFM(w io.Writer, fb *Framebuffer) error {
+	bw := bufio.NewWriter(w)
+	// A negative scale means little endian
+	fmt.Fprintf(bw, "PF\n%d %d\n-1.0\n", fb.Width, fb.Height)
+
+	// Rows are stored from the bottom up
+	row := make([]float32, 3*fb.Width)
+	for y := fb.Height - 1; y >= 0; y-- {
+		for x := 0; x < fb.Width; x++ {
+			c := fb.GetPixel(x, y)
+			row[3*x], row[3*x+1], row[3*x+2] = float32(c.X), float32(c.Y), float32(c.Z)
+		}
+		binary.Write(bw, binary.LittleEndian, row)
+	}
+	return bw.Flush()
+}
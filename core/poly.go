@@ -0,0 +1,125 @@
+package core
+
+import (
+	"math"
+	"sort"
+)
+
+// EvalPoly evaluates the polynomial with coefficients c, highest power first, at x
+func EvalPoly(c []float64, x float64) float64 {
+	v := 0.0
+	for _, ci := range c {
+		v = v*x + ci
+	}
+	return v
+}
+
+// derivative returns the coefficients of the derivative of the polynomial c
+func derivative(c []float64) []float64 {
+	n := len(c) - 1
+	d := make([]float64, n)
+	for i := 0; i < n; i++ {
+		d[i] = c[i] * float64(n-i)
+	}
+	return d
+}
+
+// PolyRoots finds the real roots of the polynomial with coefficients c, highest power first,
+// that lie in [lo, hi], returned in increasing order.
+//
+// Rather than using closed forms, which lose a lot of precision for cubics and quartics, the
+// roots of the derivative are found first. They split [lo, hi] into intervals where the
+// polynomial is monotonic, so each contains at most one root which is found by safeguarded
+// Newton's method. Roots of even multiplicity, where the sign doesn't change, are not found
+func PolyRoots(c []float64, lo, hi float64) []float64 {
+	// Drop leading zeros so the degree is correct
+	for len(c) > 0 && c[0] == 0 {
+		c = c[1:]
+	}
+
+	switch len(c) {
+	case 0, 1:
+		return nil
+	case 2:
+		x := -c[1] / c[0]
+		if x >= lo && x <= hi {
+			return []float64{x}
+		}
+		return nil
+	}
+
+	// The turning points of c split the range into monotonic pieces
+	bounds := []float64{lo}
+	bounds = append(bounds, PolyRoots(derivative(c), lo, hi)...)
+	bounds = append(bounds, hi)
+
+	roots := make([]float64, 0, len(c)-1)
+	for i := 0; i+1 < len(bounds); i++ {
+		a, b := bounds[i], bounds[i+1]
+		fa, fb := EvalPoly(c, a), EvalPoly(c, b)
+
+		switch {
+		case fa == 0:
+			roots = appendRoot(roots, a)
+		case fa*fb < 0:
+			roots = appendRoot(roots, monotonicRoot(c, a, b, fa))
+		}
+	}
+
+	if EvalPoly(c, hi) == 0 {
+		roots = appendRoot(roots, hi)
+	}
+
+	return roots
+}
+
+// SolveQuartic finds the real roots of ax^4 + bx^3 + cx^2 + dx + e in [lo, hi]
+func SolveQuartic(a, b, c, d, e, lo, hi float64) []float64 {
+	return PolyRoots([]float64{a, b, c, d, e}, lo, hi)
+}
+
+// appendRoot adds x to the sorted roots unless it is the same as the last one
+func appendRoot(roots []float64, x float64) []float64 {
+	if n := len(roots); n > 0 && roots[n-1] == x {
+		return roots
+	}
+
+	roots = append(roots, x)
+	if !sort.Float64sAreSorted(roots) {
+		sort.Float64s(roots)
+	}
+	return roots
+}
+
+// monotonicRoot finds the single root of c in [a, b] where c(a) = fa and c(a), c(b) differ in sign.
+// Newton steps are taken while they stay inside the bracket, otherwise it bisects
+func monotonicRoot(c []float64, a, b, fa float64) float64 {
+	dc := derivative(c)
+	x := 0.5 * (a + b)
+
+	for i := 0; i < 100; i++ {
+		fx := EvalPoly(c, x)
+		if fx == 0 {
+			return x
+		}
+
+		// Shrink the bracket to the half containing the root
+		if (fx < 0) == (fa < 0) {
+			a, fa = x, fx
+		} else {
+			b = x
+		}
+
+		next := x - fx/EvalPoly(dc, x)
+		if math.IsNaN(next) || next <= a || next >= b {
+			next = 0.5 * (a + b)
+		}
+
+		if math.Abs(next-x) <= 1e-15*math.Max(1, math.Abs(x)) {
+			return next
+		}
+		x = next
+	}
+
+	return x
+}
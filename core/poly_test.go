@@ -0,0 +1,114 @@
+package core
+
+import (
+	"math"
+	"testing"
+)
+
+// polyFromRoots returns the coefficients of the monic polynomial with the given roots, highest
+// power first
+func polyFromRoots(roots ...float64) []float64 {
+	c := []float64{1}
+	for _, r := range roots {
+		next := make([]float64, len(c)+1)
+		for i, ci := range c {
+			next[i] += ci
+			next[i+1] -= ci * r
+		}
+		c = next
+	}
+	return c
+}
+
+// polyMul returns the product of the polynomials a and b, highest power first
+func polyMul(a, b []float64) []float64 {
+	c := make([]float64, len(a)+len(b)-1)
+	for i, ai := range a {
+		for j, bj := range b {
+			c[i+j] += ai * bj
+		}
+	}
+	return c
+}
+
+func checkRoots(t *testing.T, name string, got, want []float64, tol float64) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Errorf("%s: got roots %v, want %v", name, got, want)
+		return
+	}
+	for i := range want {
+		if math.Abs(got[i]-want[i]) > tol {
+			t.Errorf("%s: root %d is %v, want %v within %v", name, i, got[i], want[i], tol)
+		}
+	}
+}
+
+func TestPolyRootsSimple(t *testing.T) {
+	checkRoots(t, "linear", PolyRoots([]float64{2, -3}, -10, 10), []float64{1.5}, 1e-15)
+	checkRoots(t, "quadratic", PolyRoots(polyFromRoots(-1, 4), -10, 10), []float64{-1, 4}, 1e-12)
+	checkRoots(t, "leading zeros", PolyRoots([]float64{0, 0, 1, -1, -2}, -10, 10), []float64{-1, 2}, 1e-12)
+	checkRoots(t, "quartic", PolyRoots(polyFromRoots(-3, -1, 2, 5), -10, 10), []float64{-3, -1, 2, 5}, 1e-12)
+	checkRoots(t, "no real roots", PolyRoots([]float64{1, 0, 1, 0, 1}, -10, 10), nil, 0)
+}
+
+func TestPolyRootsRange(t *testing.T) {
+	c := polyFromRoots(-3, -1, 2, 5)
+	checkRoots(t, "inside range", PolyRoots(c, -2, 3), []float64{-1, 2}, 1e-12)
+	checkRoots(t, "root on lo", PolyRoots(c, -1, 3), []float64{-1, 2}, 1e-12)
+	checkRoots(t, "root on hi", PolyRoots(c, 0, 5), []float64{2, 5}, 1e-12)
+	checkRoots(t, "empty range", PolyRoots(c, 2.5, 4.5), nil, 0)
+}
+
+func TestPolyRootsNearDouble(t *testing.T) {
+	for _, gap := range []float64{1e-3, 1e-5, 1e-6} {
+		want := []float64{-2, 1, 1 + gap, 3}
+		c := polyFromRoots(want...)
+		got := SolveQuartic(c[0], c[1], c[2], c[3], c[4], -10, 10)
+
+		// Each root moves by about the rounding error over the slope there, which is
+		// proportional to the gap
+		checkRoots(t, "near double", got, want, 1e-14/gap)
+		if len(got) == 4 && got[2] <= got[1] {
+			t.Errorf("near double %v: roots %v and %v are not in order", gap, got[1], got[2])
+		}
+	}
+}
+
+func TestPolyRootsNearDoubleWithoutCrossing(t *testing.T) {
+	// (x - 1)^2 + ε never reaches zero, so only the two outer roots are real
+	for _, eps := range []float64{1e-6, 1e-9, 1e-12} {
+		c := polyMul(polyFromRoots(-2, 3), []float64{1, -2, 1 + eps})
+		got := PolyRoots(c, -10, 10)
+		if len(got) != 2 {
+			t.Errorf("ε = %v: got roots %v, want just -2 and 3", eps, got)
+			continue
+		}
+		checkRoots(t, "lifted double", got, []float64{-2, 3}, 1e-12)
+	}
+}
+
+func TestPolyRootsClustered(t *testing.T) {
+	for _, spacing := range []float64{1e-1, 1e-2, 1e-3} {
+		want := []float64{0.5, 0.5 + spacing, 0.5 + 2*spacing, 0.5 + 3*spacing}
+		got := PolyRoots(polyFromRoots(want...), -10, 10)
+
+		// The slope at each root is about spacing^3, so the error grows as its inverse
+		checkRoots(t, "clustered", got, want, 1e-15/(spacing*spacing*spacing))
+	}
+}
+
+func TestPolyRootsResiduals(t *testing.T) {
+	c := polyFromRoots(-0.75, 0.001, 0.0011, 12)
+	for _, x := range PolyRoots(c, -100, 100) {
+		// The polynomial changes sign within a few ulps of every root found
+		step := 4 * math.Abs(x) * 2.2e-16
+		if step == 0 {
+			step = 1e-300
+		}
+		if EvalPoly(c, x-step)*EvalPoly(c, x+step) > 0 && EvalPoly(c, x) != 0 {
+			t.Errorf("no sign change around root %v", x)
+		}
+	}
+}
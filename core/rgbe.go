@@ -0,0 +1,220 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// toRGBE packs c into the shared exponent format of Radiance files
+func toRGBE(c Vector3) [4]byte {
+	m := math.Max(c.X, math.Max(c.Y, c.Z))
+	if !(m >= 1e-32) {
+		return [4]byte{}
+	}
+
+	frac, exp := math.Frexp(m)
+	scale := frac * 256 / m
+	byteOf := func(x float64) byte {
+		return byte(math.Max(0, x*scale))
+	}
+	return [4]byte{byteOf(c.X), byteOf(c.Y), byteOf(c.Z), byte(exp + 128)}
+}
+
+// fromRGBE unpacks a pixel packed by toRGBE
+func fromRGBE(p [4]byte) Vector3 {
+	if p[3] == 0 {
+		return Vector3{}
+	}
+
+	// Bytes are read from the middle of their range
+	scale := math.Ldexp(1, int(p[3])-128-8)
+	return Vector3{X: (float64(p[0]) + 0.5) * scale, Y: (float64(p[1]) + 0.5) * scale, Z: (float64(p[2]) + 0.5) * scale}
+}
+
+// WriteRGBE writes the framebuffer to w as a Radiance .hdr file with run length encoded
+// scanlines
+func WriteRGBE(w io.Writer, fb *Framebuffer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", fb.Height, fb.Width)
+
+	line := make([][4]byte, fb.Width)
+	component := make([]byte, fb.Width)
+	for y := 0; y < fb.Height; y++ {
+		for x := range line {
+			line[x] = toRGBE(fb.GetPixel(x, y))
+		}
+
+		// Run length encoding only works for these widths
+		if fb.Width < 8 || fb.Width > 0x7fff {
+			for _, p := range line {
+				bw.Write(p[:])
+			}
+			continue
+		}
+
+		bw.Write([]byte{2, 2, byte(fb.Width >> 8), byte(fb.Width)})
+		for i := 0; i < 4; i++ {
+			for x, p := range line {
+				component[x] = p[i]
+			}
+			writeRLE(bw, component)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// writeRLE writes data as runs of at least 4 equal bytes and literal stretches in between,
+// each at most 127 and 128 bytes long
+func writeRLE(w *bufio.Writer, data []byte) {
+	const minRun = 4
+	i := 0
+	for i < len(data) {
+		// Find the next run long enough to be worth it
+		start := i
+		run := 0
+		for start < len(data) {
+			run = 1
+			for start+run < len(data) && run < 127 && data[start+run] == data[start] {
+				run++
+			}
+			if run >= minRun {
+				break
+			}
+			start += run
+		}
+		if run < minRun {
+			start = len(data)
+		}
+
+		for i < start {
+			n := start - i
+			if n > 128 {
+				n = 128
+			}
+			w.WriteByte(byte(n))
+			w.Write(data[i : i+n])
+			i += n
+		}
+
+		if start < len(data) {
+			w.WriteByte(byte(128 + run))
+			w.WriteByte(data[start])
+			i = start + run
+		}
+	}
+}
+
+// ReadRGBE reads a Radiance .hdr file in the usual -Y +X orientation, with flat or run length
+// encoded scanlines
+func ReadRGBE(r io.Reader) (*Framebuffer, error) {
+	br := bufio.NewReader(r)
+
+	magic, err := br.ReadString('\n')
+	if err != nil || !strings.HasPrefix(magic, "#?") {
+		return nil, errors.New("hdr: not a Radiance file")
+	}
+
+	// The header ends with a blank line, followed by the resolution
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, fmt.Errorf("hdr: %v", err)
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("hdr: unsupported %s", line)
+		}
+	}
+
+	resolution, err := br.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("hdr: %v", err)
+	}
+	var width, height int
+	if _, err := fmt.Sscanf(resolution, "-Y %d +X %d", &height, &width); err != nil || width <= 0 || height <= 0 {
+		return nil, fmt.Errorf("hdr: unsupported resolution %q", strings.TrimSpace(resolution))
+	}
+
+	fb := NewFramebuffer(width, height)
+	line := make([][4]byte, width)
+	for y := 0; y < height; y++ {
+		if err := readRGBELine(br, line); err != nil {
+			return nil, fmt.Errorf("hdr: line %d: %v", y, err)
+		}
+		for x, p := range line {
+			fb.SetPixel(x, y, fromRGBE(p))
+		}
+	}
+
+	return fb, nil
+}
+
+// readRGBELine reads a scanline of len(line) pixels
+func readRGBELine(br *bufio.Reader, line [][4]byte) error {
+	var start [4]byte
+	if _, err := io.ReadFull(br, start[:]); err != nil {
+		return err
+	}
+
+	width := len(line)
+	if start[0] != 2 || start[1] != 2 || start[2]&0x80 != 0 || width < 8 || width > 0x7fff {
+		// Flat pixels
+		line[0] = start
+		for x := 1; x < width; x++ {
+			if _, err := io.ReadFull(br, line[x][:]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if int(start[2])<<8|int(start[3]) != width {
+		return errors.New("scanline width doesn't match the image")
+	}
+
+	for i := 0; i < 4; i++ {
+		for x := 0; x < width; {
+			count, err := br.ReadByte()
+			if err != nil {
+				return err
+			}
+
+			if count > 128 {
+				n := int(count - 128)
+				value, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if x+n > width {
+					return errors.New("run past the end of the scanline")
+				}
+				for ; n > 0; n-- {
+					line[x][i] = value
+					x++
+				}
+				continue
+			}
+
+			n := int(count)
+			if n == 0 || x+n > width {
+				return errors.New("bad literal length")
+			}
+			for ; n > 0; n-- {
+				if line[x][i], err = br.ReadByte(); err != nil {
+					return err
+				}
+				x++
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package core
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// rgbeTestImage returns a width x height image with stretches of equal pixels longer than a run
+// can hold, short runs and stretches of noise, so scanlines use every kind of RLE chunk
+func rgbeTestImage(width, height int, rng *rand.Rand) *Framebuffer {
+	fb := NewFramebuffer(width, height)
+	c := Vector3{}
+	for i := range fb.Pix {
+		switch x := i % width; {
+		case x%300 < 150:
+			if x%300 == 0 {
+				c = Vector3{X: 1, Y: 0.5, Z: 0.25}.Smult(float64(i%7 + 1))
+			}
+		case x%300 < 200:
+			if x%5 == 0 {
+				c = Vector3{X: rng.Float64(), Y: rng.Float64(), Z: rng.Float64()}
+			}
+		default:
+			c = Vector3{X: rng.Float64(), Y: 10 * rng.Float64(), Z: 1000 * rng.Float64()}
+		}
+		fb.Pix[i] = c
+	}
+	return fb
+}
+
+// checkRGBE checks every pixel of got is within the shared exponent error bound of want
+func checkRGBE(t *testing.T, got, want *Framebuffer) {
+	t.Helper()
+
+	if got.Width != want.Width || got.Height != want.Height {
+		t.Fatalf("read back a %dx%d image, want %dx%d", got.Width, got.Height, want.Width, want.Height)
+	}
+	for i, c := range want.Pix {
+		m := math.Max(c.X, math.Max(c.Y, c.Z))
+		if d := got.Pix[i].Subtract(c); math.Max(math.Abs(d.X), math.Max(math.Abs(d.Y), math.Abs(d.Z))) > m/256 {
+			t.Fatalf("pixel %d is %v, want %v within %v", i, got.Pix[i], c, m/256)
+		}
+	}
+}
+
+func TestRGBERoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// Scanlines under 8 or over 0x7fff pixels can't be run length encoded and are written flat
+	for _, width := range []int{1, 5, 7, 8, 9, 127, 128, 129, 300, 1000, 0x7fff, 0x8000, 0x8000 + 37} {
+		height := 3
+		if width > 1000 {
+			height = 1
+		}
+		fb := rgbeTestImage(width, height, rng)
+
+		var buf bytes.Buffer
+		if err := WriteRGBE(&buf, fb); err != nil {
+			t.Fatalf("width %d: %v", width, err)
+		}
+		got, err := ReadRGBE(&buf)
+		if err != nil {
+			t.Fatalf("width %d: %v", width, err)
+		}
+		checkRGBE(t, got, fb)
+	}
+}
+
+func TestRGBERunLengthEncodes(t *testing.T) {
+	fb := NewFramebuffer(1000, 10)
+	for i := range fb.Pix {
+		fb.Pix[i] = Vector3{X: 0.5, Y: 0.25, Z: 2}
+	}
+
+	var buf bytes.Buffer
+	if err := WriteRGBE(&buf, fb); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() >= 4*len(fb.Pix)/10 {
+		t.Errorf("flat image took %d bytes, want it run length encoded", buf.Len())
+	}
+
+	got, err := ReadRGBE(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRGBE(t, got, fb)
+}
+
+func TestRGBEErrorBound(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+
+	for i := 0; i < 100000; i++ {
+		// Components spread over many powers of 2, sharing the exponent of the largest
+		scale := math.Ldexp(1, rng.Intn(80)-40)
+		c := Vector3{X: rng.Float64(), Y: rng.Float64(), Z: rng.Float64()}.Smult(scale)
+		got := fromRGBE(toRGBE(c))
+
+		m := math.Max(c.X, math.Max(c.Y, c.Z))
+		for _, d := range []float64{got.X - c.X, got.Y - c.Y, got.Z - c.Z} {
+			if math.Abs(d) > m/256 {
+				t.Fatalf("%v came back as %v, more than %v out", c, got, m/256)
+			}
+		}
+	}
+}
+
+func TestRGBEZeroAndNegative(t *testing.T) {
+	for _, c := range []Vector3{{}, {X: 1e-40, Y: 1e-35}, {X: -1, Y: -2, Z: -3}} {
+		if got := fromRGBE(toRGBE(c)); got != (Vector3{}) {
+			t.Errorf("%v came back as %v, want black", c, got)
+		}
+	}
+
+	// Negative components of a bright pixel are clamped to the smallest value
+	got := fromRGBE(toRGBE(Vector3{X: -1, Y: 1, Z: 0}))
+	if got.X < 0 || got.X > 1.0/256 {
+		t.Errorf("negative component came back as %v, want it clamped near 0", got.X)
+	}
+}
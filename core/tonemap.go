@@ -0,0 +1,91 @@
+package core
+
+import (
+	"fmt"
+	"math"
+)
+
+// ToneMapper compresses radiance into the [0, 1] range a display can show
+type ToneMapper int
+
+const (
+	// ToneClamp cuts off everything brighter than 1
+	ToneClamp ToneMapper = iota
+	// ToneReinhard scales colours by 1 / (1 + L) where L is their luminance, so highlights
+	// approach white slowly without changing hue
+	ToneReinhard
+	// ToneACES uses Narkowicz's fit to the ACES filmic curve, which adds contrast and rolls off
+	// highlights like film
+	ToneACES
+)
+
+var toneMapperNames = []string{"clamp", "reinhard", "aces"}
+
+func (t ToneMapper) String() string {
+	if int(t) < len(toneMapperNames) {
+		return toneMapperNames[t]
+	}
+	return fmt.Sprintf("ToneMapper(%d)", int(t))
+}
+
+// ParseToneMapper returns the ToneMapper called name
+func ParseToneMapper(name string) (ToneMapper, error) {
+	for i, n := range toneMapperNames {
+		if n == name {
+			return ToneMapper(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown tone mapper %q, want clamp, reinhard or aces", name)
+}
+
+// Map applies the tone curve to the linear colour c, giving a linear colour in [0, 1]
+func (t ToneMapper) Map(c Vector3) Vector3 {
+	switch t {
+	case ToneReinhard:
+		c = c.Smult(1 / (1 + math.Max(0, Luminance(c))))
+	case ToneACES:
+		aces := func(x float64) float64 {
+			return x * (2.51*x + 0.03) / (x*(2.43*x+0.59) + 0.14)
+		}
+		c = Vector3{X: aces(math.Max(0, c.X)), Y: aces(math.Max(0, c.Y)), Z: aces(math.Max(0, c.Z))}
+	}
+
+	return Vector3{X: clamp01(c.X), Y: clamp01(c.Y), Z: clamp01(c.Z)}
+}
+
+func clamp01(x float64) float64 {
+	// NaN fails both comparisons, so is caught here too
+	if !(x > 0) {
+		return 0
+	}
+	return math.Min(1, x)
+}
+
+// SRGBEncode applies the sRGB transfer function to the linear value x in [0, 1]
+func SRGBEncode(x float64) float64 {
+	if x <= 0.0031308 {
+		return 12.92 * x
+	}
+	return 1.055*math.Pow(x, 1/2.4) - 0.055
+}
+
+// SRGBDecode is the inverse of SRGBEncode
+func SRGBDecode(x float64) float64 {
+	if x <= 0.04045 {
+		return x / 12.92
+	}
+	return math.Pow((x+0.055)/1.055, 2.4)
+}
+
+// Display describes how the radiance of a render is turned into colours for a screen
+type Display struct {
+	ToneMapper ToneMapper
+	// Exposure brightens the image by this many stops before tone mapping
+	Exposure float64
+}
+
+// Apply returns the sRGB encoded colour in [0, 1] to display for the radiance c
+func (d Display) Apply(c Vector3) Vector3 {
+	c = d.ToneMapper.Map(c.Smult(math.Exp2(d.Exposure)))
+	return Vector3{X: SRGBEncode(c.X), Y: SRGBEncode(c.Y), Z: SRGBEncode(c.Z)}
+}
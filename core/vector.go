@@ -24,6 +24,11 @@ func (a Vector3) Smult(n float64) Vector3 {
 	return Vector3{n * a.X, n * a.Y, n * a.Z}
 }
 
+// Mult multiplies two vectors component wise, used to filter one colour by another
+func (a Vector3) Mult(b Vector3) Vector3 {
+	return Vector3{a.X * b.X, a.Y * b.Y, a.Z * b.Z}
+}
+
 // Dot takes the dot product of two vectors
 func (a Vector3) Dot(b Vector3) float64 {
 	return a.X*b.X + a.Y*b.Y + a.Z*b.Z
@@ -54,3 +59,15 @@ func (a Vector3) Normalize() Vector3 {
 func (a Vector3) AsSlice() []float64 {
 	return []float64{a.X, a.Y, a.Z}
 }
+
+// OrthonormalBasis returns two unit vectors perpendicular to the unit vector n and each other
+func OrthonormalBasis(n Vector3) (Vector3, Vector3) {
+	// Pick the axis least aligned with n to cross with
+	a := Vector3{1, 0, 0}
+	if math.Abs(n.X) > 0.9 {
+		a = Vector3{0, 1, 0}
+	}
+
+	t := a.Cross(n).Normalize()
+	return t, n.Cross(t)
+}
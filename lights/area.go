@@ -0,0 +1,262 @@
+package lights
+
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/core"
+)
+
+// AreaLight is a light with a shape, which casts soft shadows and can be hit by rays. Each is
+// one sided unless it says otherwise and gives off the same radiance in every direction it
+// lights
+type AreaLight interface {
+	Light
+	// Pdf returns the density Sample picks the direction wi from p with
+	Pdf(p, wi vector3) float64
+	// Intersect returns the distance along the unit vector d from s to the light and the
+	// radiance leaving it back along the ray
+	Intersect(s, d vector3) (float64, vector3, bool)
+}
+
+// samples returns n, or 1 if it isn't positive
+func samples(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+// areaPdf converts a density per unit area at a point dist away, where the light's normal is at
+// cos to the direction, into one per unit solid angle
+func areaPdf(area, dist, cos float64) float64 {
+	if cos == 0 {
+		return 0
+	}
+	return dist * dist / (area * math.Abs(cos))
+}
+
+// Rect is a parallelogram shaped light with corners Corner, Corner + U, Corner + V and
+// Corner + U + V. It shines from the side U × V points to
+type Rect struct {
+	Corner, U, V vector3
+	Radiance     vector3
+	TwoSided     bool
+	Samples      int
+}
+
+// NewRect creates a rectangular light centred on centre with sides u and v. intensity is the
+// radiant intensity along the normal, so from far away it is as bright as a point light with
+// the same intensity
+func NewRect(centre, u, v, intensity vector3, twoSided bool, samples int) *Rect {
+	area := u.Cross(v).Length()
+	corner := centre.Subtract(u.Smult(0.5)).Subtract(v.Smult(0.5))
+	return &Rect{corner, u, v, intensity.Smult(1 / area), twoSided, samples}
+}
+
+func (r *Rect) normal() vector3 {
+	return r.U.Cross(r.V).Normalize()
+}
+
+// emitted returns the radiance leaving towards -d
+func (r *Rect) emitted(d vector3) vector3 {
+	if !r.TwoSided && d.Dot(r.normal()) >= 0 {
+		return vector3{}
+	}
+	return r.Radiance
+}
+
+// Sample implements the AreaLight function, picking a point uniformly over the area
+func (r *Rect) Sample(p vector3, u1, u2 float64) (LightSample, bool) {
+	point := r.Corner.Add(r.U.Smult(u1)).Add(r.V.Smult(u2))
+	toLight := point.Subtract(p)
+	dist := toLight.Length()
+	if dist == 0 {
+		return LightSample{}, false
+	}
+
+	wi := toLight.Smult(1 / dist)
+	radiance := r.emitted(wi)
+	pdf := areaPdf(r.U.Cross(r.V).Length(), dist, wi.Dot(r.normal()))
+	if radiance == (vector3{}) || pdf == 0 {
+		return LightSample{}, false
+	}
+	return LightSample{wi, dist, radiance, pdf}, true
+}
+
+// Pdf implements the AreaLight function
+func (r *Rect) Pdf(p, wi vector3) float64 {
+	t, _, ok := r.Intersect(p, wi)
+	if !ok {
+		return 0
+	}
+	return areaPdf(r.U.Cross(r.V).Length(), t, wi.Dot(r.normal()))
+}
+
+// Intersect implements the AreaLight function
+func (r *Rect) Intersect(s, d vector3) (float64, vector3, bool) {
+	n := r.U.Cross(r.V)
+	denom := d.Dot(n)
+	if denom == 0 {
+		return 0, vector3{}, false
+	}
+
+	t := r.Corner.Subtract(s).Dot(n) / denom
+	if t <= 0 {
+		return 0, vector3{}, false
+	}
+
+	// Find the coordinates of the hit along U and V
+	offset := s.Add(d.Smult(t)).Subtract(r.Corner)
+	nn := n.Dot(n)
+	a := offset.Cross(r.V).Dot(n) / nn
+	b := r.U.Cross(offset).Dot(n) / nn
+	if a < 0 || a > 1 || b < 0 || b > 1 {
+		return 0, vector3{}, false
+	}
+
+	return t, r.emitted(d), true
+}
+
+// SampleCount implements the AreaLight function
+func (r *Rect) SampleCount() int {
+	return samples(r.Samples)
+}
+
+// Disk is a round light of Radius around Centre, shining towards Normal
+type Disk struct {
+	Centre, Normal vector3
+	Radius         float64
+	Radiance       vector3
+	TwoSided       bool
+	Samples        int
+}
+
+// NewDisk creates a disk light. intensity is the radiant intensity along the normal, see NewRect
+func NewDisk(centre, normal vector3, radius float64, intensity vector3, twoSided bool, samples int) *Disk {
+	return &Disk{centre, normal.Normalize(), radius, intensity.Smult(1 / (math.Pi * radius * radius)), twoSided, samples}
+}
+
+func (disk *Disk) emitted(d vector3) vector3 {
+	if !disk.TwoSided && d.Dot(disk.Normal) >= 0 {
+		return vector3{}
+	}
+	return disk.Radiance
+}
+
+// Sample implements the AreaLight function, picking a point uniformly over the area
+func (disk *Disk) Sample(p vector3, u1, u2 float64) (LightSample, bool) {
+	x, y := ConcentricDisk(u1, u2)
+	t, b := core.OrthonormalBasis(disk.Normal)
+	point := disk.Centre.Add(t.Smult(x * disk.Radius)).Add(b.Smult(y * disk.Radius))
+
+	toLight := point.Subtract(p)
+	dist := toLight.Length()
+	if dist == 0 {
+		return LightSample{}, false
+	}
+
+	wi := toLight.Smult(1 / dist)
+	radiance := disk.emitted(wi)
+	pdf := areaPdf(math.Pi*disk.Radius*disk.Radius, dist, wi.Dot(disk.Normal))
+	if radiance == (vector3{}) || pdf == 0 {
+		return LightSample{}, false
+	}
+	return LightSample{wi, dist, radiance, pdf}, true
+}
+
+// Pdf implements the AreaLight function
+func (disk *Disk) Pdf(p, wi vector3) float64 {
+	t, _, ok := disk.Intersect(p, wi)
+	if !ok {
+		return 0
+	}
+	return areaPdf(math.Pi*disk.Radius*disk.Radius, t, wi.Dot(disk.Normal))
+}
+
+// Intersect implements the AreaLight function
+func (disk *Disk) Intersect(s, d vector3) (float64, vector3, bool) {
+	denom := d.Dot(disk.Normal)
+	if denom == 0 {
+		return 0, vector3{}, false
+	}
+
+	t := disk.Centre.Subtract(s).Dot(disk.Normal) / denom
+	if t <= 0 || s.Add(d.Smult(t)).Subtract(disk.Centre).Length() > disk.Radius {
+		return 0, vector3{}, false
+	}
+	return t, disk.emitted(d), true
+}
+
+// SampleCount implements the AreaLight function
+func (disk *Disk) SampleCount() int {
+	return samples(disk.Samples)
+}
+
+// Sphere is a ball shaped light, which shines from its whole surface
+type Sphere struct {
+	Centre   vector3
+	Radius   float64
+	Radiance vector3
+	Samples  int
+}
+
+// NewSphere creates a sphere light. intensity is the radiant intensity in every direction, see
+// NewRect
+func NewSphere(centre vector3, radius float64, intensity vector3, samples int) *Sphere {
+	return &Sphere{centre, radius, intensity.Smult(1 / (math.Pi * radius * radius)), samples}
+}
+
+// cone returns the direction from p to the centre of the sphere and 1 - cos of the half angle of
+// the cone it fills as seen from p. It returns false from inside it
+func (sphere *Sphere) cone(p vector3) (vector3, float64, bool) {
+	return SphereCone(sphere.Centre, sphere.Radius, p)
+}
+
+// Sample implements the AreaLight function, picking a direction uniformly in the cone of
+// directions the sphere fills
+func (sphere *Sphere) Sample(p vector3, u1, u2 float64) (LightSample, bool) {
+	axis, oneMinusCosMax, ok := sphere.cone(p)
+	if !ok {
+		return LightSample{}, false
+	}
+
+	wi := SampleCone(axis, oneMinusCosMax, u1, u2)
+	dist, _, ok := sphere.Intersect(p, wi)
+	if !ok {
+		// Directions on the very edge of the cone can just miss from rounding
+		dist = math.Sqrt(math.Max(0, sphere.Centre.Subtract(p).Dot(sphere.Centre.Subtract(p))-sphere.Radius*sphere.Radius))
+	}
+	return LightSample{wi, dist, sphere.Radiance, 1 / (2 * math.Pi * oneMinusCosMax)}, true
+}
+
+// Pdf implements the AreaLight function
+func (sphere *Sphere) Pdf(p, wi vector3) float64 {
+	axis, oneMinusCosMax, ok := sphere.cone(p)
+	if !ok || wi.Dot(axis) < 1-oneMinusCosMax {
+		return 0
+	}
+	return 1 / (2 * math.Pi * oneMinusCosMax)
+}
+
+// Intersect implements the AreaLight function
+func (sphere *Sphere) Intersect(s, d vector3) (float64, vector3, bool) {
+	offset := s.Subtract(sphere.Centre)
+	b := d.Dot(offset)
+	c := offset.Dot(offset) - sphere.Radius*sphere.Radius
+
+	disc := b*b - c
+	if disc < 0 {
+		return 0, vector3{}, false
+	}
+
+	t := -b - math.Sqrt(disc)
+	if t <= 0 {
+		t = -b + math.Sqrt(disc)
+	}
+	return t, sphere.Radiance, t > 0
+}
+
+// SampleCount implements the AreaLight function
+func (sphere *Sphere) SampleCount() int {
+	return samples(sphere.Samples)
+}
@@ -0,0 +1,101 @@
+package lights
+
+import "sort"
+
+// distribution1D picks from n equal width buckets of [0, 1) in proportion to the function
+// values f given for them
+type distribution1D struct {
+	f   []float64
+	cdf []float64
+	// integral is the integral of the function over [0, 1)
+	integral float64
+}
+
+func newDistribution1D(f []float64) distribution1D {
+	n := len(f)
+	cdf := make([]float64, n+1)
+	for i, v := range f {
+		cdf[i+1] = cdf[i] + v/float64(n)
+	}
+
+	integral := cdf[n]
+	for i := 1; i <= n; i++ {
+		if integral == 0 {
+			// Nothing to prefer, so pick uniformly
+			cdf[i] = float64(i) / float64(n)
+		} else {
+			cdf[i] /= integral
+		}
+	}
+
+	return distribution1D{f, cdf, integral}
+}
+
+// sample returns the point in [0, 1) picked with u, its density and its bucket
+func (d distribution1D) sample(u float64) (float64, float64, int) {
+	n := len(d.f)
+	i := sort.Search(n, func(i int) bool { return d.cdf[i+1] > u })
+	if i == n {
+		i = n - 1
+	}
+
+	// Place it within the bucket by how far u is through its part of the cdf
+	t := u - d.cdf[i]
+	if width := d.cdf[i+1] - d.cdf[i]; width > 0 {
+		t /= width
+	}
+	return (float64(i) + t) / float64(n), d.pdf(i), i
+}
+
+// pdf returns the density of the points in bucket i
+func (d distribution1D) pdf(i int) float64 {
+	if d.integral == 0 {
+		return 1
+	}
+	return d.f[i] / d.integral
+}
+
+// distribution2D picks points in the unit square in proportion to a function tabulated on a
+// grid, by picking the row from the marginal distribution and then the column within it
+type distribution2D struct {
+	rows     []distribution1D
+	marginal distribution1D
+}
+
+// newDistribution2D builds the distribution of the width x height grid f, stored by rows
+func newDistribution2D(f []float64, width, height int) distribution2D {
+	rows := make([]distribution1D, height)
+	integrals := make([]float64, height)
+	for y := range rows {
+		rows[y] = newDistribution1D(f[y*width : (y+1)*width])
+		integrals[y] = rows[y].integral
+	}
+
+	return distribution2D{rows, newDistribution1D(integrals)}
+}
+
+// sample returns the point (u, v) picked with u1 and u2 and its density over the unit square
+func (d distribution2D) sample(u1, u2 float64) (float64, float64, float64) {
+	v, pdfV, y := d.marginal.sample(u2)
+	u, pdfU, _ := d.rows[y].sample(u1)
+	return u, v, pdfU * pdfV
+}
+
+// pdf returns the density sample picks (u, v) with
+func (d distribution2D) pdf(u, v float64) float64 {
+	y := clampIndex(int(v*float64(len(d.rows))), len(d.rows))
+	row := d.rows[y]
+	x := clampIndex(int(u*float64(len(row.f))), len(row.f))
+	return row.pdf(x) * d.marginal.pdf(y)
+}
+
+// clampIndex clamps i into [0, n)
+func clampIndex(i, n int) int {
+	if i < 0 {
+		return 0
+	}
+	if i >= n {
+		return n - 1
+	}
+	return i
+}
@@ -0,0 +1,5 @@
+/*
+	Package lights contains the light sources that can be added to a scene in
+	the ray tracer
+*/
+package lights
@@ -0,0 +1,367 @@
+package lights
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/benvardy/raytracing/core"
+)
+
+// Background is the light arriving from infinitely far away, which rays that miss everything
+// see. Directions are in world space, where +z is up
+type Background interface {
+	// Radiance returns the light arriving from the direction of the unit vector d
+	Radiance(d vector3) vector3
+}
+
+// directionToUV returns where the unit vector d is in an equirectangular map, with u going
+// around the horizon from +x and v from the zenith at 0 to the nadir at 1
+func directionToUV(d vector3) (float64, float64) {
+	u := math.Atan2(d.Y, d.X) / (2 * math.Pi)
+	if u < 0 {
+		u++
+	}
+	return u, math.Acos(math.Max(-1, math.Min(1, d.Z))) / math.Pi
+}
+
+// uvToDirection is the inverse of directionToUV, also returning sin θ
+func uvToDirection(u, v float64) (vector3, float64) {
+	phi, theta := 2*math.Pi*u, math.Pi*v
+	sinTheta := math.Sin(theta)
+	return vector3{X: math.Cos(phi) * sinTheta, Y: math.Sin(phi) * sinTheta, Z: math.Cos(theta)}, sinTheta
+}
+
+// rotateZ turns d by angle radians about the z axis
+func rotateZ(d vector3, angle float64) vector3 {
+	sin, cos := math.Sin(angle), math.Cos(angle)
+	return vector3{X: d.X*cos - d.Y*sin, Y: d.X*sin + d.Y*cos, Z: d.Z}
+}
+
+// Equirect is a background from an equirectangular image, also called a latitude-longitude
+// map, where the top row is straight up and the middle row the horizon. Rotation turns it
+// about the vertical axis, in radians, and Scale multiplies the radiance in it
+type Equirect struct {
+	Image    *core.Framebuffer
+	Scale    float64
+	Rotation float64
+}
+
+// LoadEquirect reads an equirectangular map from a Radiance HDR or OpenEXR file
+func LoadEquirect(fname string, scale, rotation float64) (*Equirect, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var fb *core.Framebuffer
+	switch ext := strings.ToLower(filepath.Ext(fname)); ext {
+	case ".hdr":
+		fb, err = core.ReadRGBE(file)
+	case ".exr":
+		fb, err = core.ReadEXR(file)
+	default:
+		return nil, fmt.Errorf("%s: unknown environment map format %q, want .hdr or .exr", fname, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+
+	return &Equirect{fb, scale, rotation}, nil
+}
+
+// pixel returns the pixel at (x, y), wrapping around horizontally and clamping vertically
+func (e *Equirect) pixel(x, y int) vector3 {
+	w := e.Image.Width
+	x = ((x % w) + w) % w
+	return e.Image.GetPixel(x, clampIndex(y, e.Image.Height))
+}
+
+// Radiance implements the Background function, interpolating between pixels
+func (e *Equirect) Radiance(d vector3) vector3 {
+	u, v := directionToUV(rotateZ(d, -e.Rotation))
+
+	x := u*float64(e.Image.Width) - 0.5
+	y := v*float64(e.Image.Height) - 0.5
+	x0, y0 := math.Floor(x), math.Floor(y)
+	tx, ty := x-x0, y-y0
+	ix, iy := int(x0), int(y0)
+
+	top := e.pixel(ix, iy).Smult(1 - tx).Add(e.pixel(ix+1, iy).Smult(tx))
+	bottom := e.pixel(ix, iy+1).Smult(1 - tx).Add(e.pixel(ix+1, iy+1).Smult(tx))
+	return top.Smult(1 - ty).Add(bottom.Smult(ty)).Smult(e.Scale)
+}
+
+// Gradient is a simple sky that blends from Horizon to Zenith going up, with a plain Ground
+// below the horizon
+type Gradient struct {
+	Zenith, Horizon, Ground vector3
+}
+
+// Radiance implements the Background function
+func (g *Gradient) Radiance(d vector3) vector3 {
+	if d.Z < 0 {
+		return g.Ground
+	}
+	return g.Horizon.Smult(1 - d.Z).Add(g.Zenith.Smult(d.Z))
+}
+
+// SunSky is a clear sky lit by the sun, using the analytic model of Preetham, Shirley and Smits
+// "A Practical Analytic Model for Daylight". Sun is the unit vector towards the sun and
+// Turbidity how hazy the air is, from 2 for very clear to 10 for hazy. SkyScale converts the
+// model's luminance in kcd/m² into scene units. The sun is a disc of AngularRadius radians
+// that gives SunIrradiance to a surface facing it, reddened by the air it passes through. The
+// Ground below the horizon reflects the light of the sky at the horizon
+type SunSky struct {
+	Sun           vector3
+	Turbidity     float64
+	SkyScale      float64
+	SunIrradiance float64
+	AngularRadius float64
+	Ground        vector3
+
+	// The sky's zenith colour and the Perez coefficients for Y, x and y
+	zenith            [3]float64
+	perez             [3][5]float64
+	thetaSun          float64
+	sunColour         vector3
+	sunOneMinusCosMax float64
+}
+
+// NewSunSky creates the sky for the sun in the direction sun
+func NewSunSky(sun vector3, turbidity, skyScale, sunIrradiance, angularRadius float64, ground vector3) *SunSky {
+	s := &SunSky{Sun: sun.Normalize(), Turbidity: turbidity, SkyScale: skyScale, SunIrradiance: sunIrradiance, AngularRadius: angularRadius, Ground: ground}
+
+	// The model is only defined with the sun above the horizon
+	s.thetaSun = math.Min(math.Acos(math.Max(-1, math.Min(1, s.Sun.Z))), math.Pi/2-1e-3)
+	T, theta := turbidity, s.thetaSun
+
+	chi := (4.0/9 - T/120) * (math.Pi - 2*theta)
+	yz := (4.0453*T-4.9710)*math.Tan(chi) - 0.2155*T + 2.4192
+
+	t2, t3 := theta*theta, theta*theta*theta
+	xz := T*T*(0.00166*t3-0.00375*t2+0.00209*theta) +
+		T*(-0.02903*t3+0.06377*t2-0.03202*theta+0.00394) +
+		(0.11693*t3 - 0.21196*t2 + 0.06052*theta + 0.25886)
+	yyz := T*T*(0.00275*t3-0.00610*t2+0.00317*theta) +
+		T*(-0.04214*t3+0.08970*t2-0.04153*theta+0.00516) +
+		(0.15346*t3 - 0.26756*t2 + 0.06670*theta + 0.26688)
+	s.zenith = [3]float64{yz, xz, yyz}
+
+	s.perez = [3][5]float64{
+		{0.1787*T - 1.4630, -0.3554*T + 0.4275, -0.0227*T + 5.3251, 0.1206*T - 2.5771, -0.0670*T + 0.3703},
+		{-0.0193*T - 0.2592, -0.0665*T + 0.0008, -0.0004*T + 0.2125, -0.0641*T - 0.8989, -0.0033*T + 0.0452},
+		{-0.0167*T - 0.2608, -0.0950*T + 0.0092, -0.0079*T + 0.2102, -0.0441*T - 1.6537, -0.0109*T + 0.0529},
+	}
+
+	s.sunColour = sunTransmittance(s.thetaSun, T)
+	half := math.Sin(angularRadius / 2)
+	s.sunOneMinusCosMax = 2 * half * half
+	return s
+}
+
+// sunTransmittance returns the fraction of red, green and blue sunlight that makes it through
+// the air with the sun theta from the zenith, from Rayleigh scattering by the air and
+// scattering by haze, using Kasten's formula for the air mass
+func sunTransmittance(theta, turbidity float64) vector3 {
+	deg := theta * 180 / math.Pi
+	mass := 1 / (math.Cos(theta) + 0.15*math.Pow(93.885-deg, -1.253))
+	beta := 0.04608*turbidity - 0.04586
+
+	channel := func(lambda float64) float64 {
+		rayleigh := 0.008735 * math.Pow(lambda, -4.08)
+		haze := beta * math.Pow(lambda, -1.3)
+		return math.Exp(-(rayleigh + haze) * mass)
+	}
+	// Wavelengths in micrometres for red, green and blue
+	return vector3{X: channel(0.65), Y: channel(0.57), Z: channel(0.475)}
+}
+
+// perezF is the Perez sky distribution for a direction theta from the zenith and gamma from
+// the sun
+func perezF(c [5]float64, theta, gamma float64) float64 {
+	cosGamma := math.Cos(gamma)
+	return (1 + c[0]*math.Exp(c[1]/math.Cos(theta))) * (1 + c[2]*math.Exp(c[3]*gamma) + c[4]*cosGamma*cosGamma)
+}
+
+// sky returns the light from the sky alone in the direction of the unit vector d
+func (s *SunSky) sky(d vector3) vector3 {
+	below := d.Z < 0
+	if d.Z < 1e-3 {
+		// Below the horizon the ground reflects the sky just above it
+		d = vector3{X: d.X, Y: d.Y, Z: 1e-3}.Normalize()
+	}
+
+	theta := math.Acos(d.Z)
+	gamma := math.Acos(math.Max(-1, math.Min(1, d.Dot(s.Sun))))
+
+	var yxy [3]float64
+	for i := range yxy {
+		yxy[i] = s.zenith[i] * perezF(s.perez[i], theta, gamma) / perezF(s.perez[i], 0, s.thetaSun)
+	}
+
+	Y, x, y := yxy[0], yxy[1], yxy[2]
+	X, Z := x/y*Y, (1-x-y)/y*Y
+	c := vector3{
+		X: 3.2406*X - 1.5372*Y - 0.4986*Z,
+		Y: -0.9689*X + 1.8758*Y + 0.0415*Z,
+		Z: 0.0557*X - 0.2040*Y + 1.0570*Z,
+	}
+	c = vector3{X: math.Max(0, c.X), Y: math.Max(0, c.Y), Z: math.Max(0, c.Z)}.Smult(s.SkyScale)
+
+	if below {
+		return c.Mult(s.Ground)
+	}
+	return c
+}
+
+// sunCone returns the direction of the sun and 1 - cos of its angular radius, or false if it
+// has no disc or has set
+func (s *SunSky) sunCone() (vector3, float64, bool) {
+	return s.Sun, s.sunOneMinusCosMax, s.sunOneMinusCosMax > 0 && s.SunIrradiance > 0 && s.Sun.Z > 0
+}
+
+// Radiance implements the Background function
+func (s *SunSky) Radiance(d vector3) vector3 {
+	c := s.sky(d)
+	if axis, oneMinusCosMax, ok := s.sunCone(); ok && d.Dot(axis) >= 1-oneMinusCosMax {
+		c = c.Add(s.sunColour.Smult(s.SunIrradiance / (2 * math.Pi * oneMinusCosMax)))
+	}
+	return c
+}
+
+// envWidth and envHeight are the size of the grid procedural backgrounds are tabulated on for
+// sampling
+const envWidth, envHeight = 512, 256
+
+// sunChance is the chance of sampling the sun's disc directly rather than the rest of the sky
+const sunChance = 0.5
+
+// Environment lights the scene with a Background. It picks directions in proportion to how
+// bright the background is, and aims straight for the sun of a SunSky
+type Environment struct {
+	Background Background
+	Samples    int
+
+	dist          distribution2D
+	width, height int
+}
+
+// NewEnvironment creates an environment light for the background, tabulating it so it can
+// be sampled
+func NewEnvironment(background Background, samples int) *Environment {
+	width, height := envWidth, envHeight
+	if e, ok := background.(*Equirect); ok {
+		width, height = e.Image.Width, e.Image.Height
+	}
+
+	// Weight by sin θ as the rows near the poles cover less of the sphere
+	f := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			d, sinTheta := uvToDirection((float64(x)+0.5)/float64(width), (float64(y)+0.5)/float64(height))
+			f[y*width+x] = math.Max(0, core.Luminance(tabulated(background, d))) * sinTheta
+		}
+	}
+
+	return &Environment{background, samples, newDistribution2D(f, width, height), width, height}
+}
+
+// tabulated returns the background in the direction d as used for sampling, leaving out the
+// sun of a SunSky as that is sampled by itself
+func tabulated(background Background, d vector3) vector3 {
+	switch b := background.(type) {
+	case *SunSky:
+		return b.sky(d)
+	case *Equirect:
+		return b.Radiance(rotateZ(d, b.Rotation))
+	}
+	return background.Radiance(d)
+}
+
+// toMap and fromMap turn world directions to and from the tabulated grid's directions
+func (e *Environment) toMap(d vector3) vector3 {
+	if b, ok := e.Background.(*Equirect); ok {
+		return rotateZ(d, -b.Rotation)
+	}
+	return d
+}
+
+func (e *Environment) fromMap(d vector3) vector3 {
+	if b, ok := e.Background.(*Equirect); ok {
+		return rotateZ(d, b.Rotation)
+	}
+	return d
+}
+
+// sun returns the cone of the sun, if the background has one
+func (e *Environment) sun() (vector3, float64, bool) {
+	if s, ok := e.Background.(*SunSky); ok {
+		return s.sunCone()
+	}
+	return vector3{}, 0, false
+}
+
+// Radiance returns the light arriving from the direction of the unit vector d
+func (e *Environment) Radiance(d vector3) vector3 {
+	return e.Background.Radiance(d)
+}
+
+// Sample implements the Light function
+func (e *Environment) Sample(p vector3, u1, u2 float64) (LightSample, bool) {
+	var wi vector3
+	if axis, oneMinusCosMax, ok := e.sun(); ok && u1 < sunChance {
+		wi = SampleCone(axis, oneMinusCosMax, u1/sunChance, u2)
+	} else {
+		if ok {
+			u1 = (u1 - sunChance) / (1 - sunChance)
+		}
+
+		u, v, pdf := e.dist.sample(u1, u2)
+		if pdf == 0 {
+			return LightSample{}, false
+		}
+		wi, _ = uvToDirection(u, v)
+		wi = e.fromMap(wi)
+	}
+
+	pdf := e.Pdf(p, wi)
+	if pdf == 0 {
+		return LightSample{}, false
+	}
+	return LightSample{wi, math.Inf(1), e.Radiance(wi), pdf}, true
+}
+
+// Pdf implements the AreaLight function
+func (e *Environment) Pdf(_, wi vector3) float64 {
+	u, v := directionToUV(e.toMap(wi))
+	_, sinTheta := uvToDirection(u, v)
+
+	pdf := 0.0
+	if sinTheta > 0 {
+		// The map's density is over the unit square, which covers 2π by π radians
+		pdf = e.dist.pdf(u, v) / (2 * math.Pi * math.Pi * sinTheta)
+	}
+
+	if axis, oneMinusCosMax, ok := e.sun(); ok {
+		pdf *= 1 - sunChance
+		if wi.Dot(axis) >= 1-oneMinusCosMax {
+			pdf += sunChance / (2 * math.Pi * oneMinusCosMax)
+		}
+	}
+	return pdf
+}
+
+// Intersect implements the AreaLight function. Every ray reaches the environment, infinitely
+// far away
+func (e *Environment) Intersect(_, d vector3) (float64, vector3, bool) {
+	return math.Inf(1), e.Radiance(d), true
+}
+
+// SampleCount implements the Light function
+func (e *Environment) SampleCount() int {
+	return samples(e.Samples)
+}
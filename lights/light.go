@@ -0,0 +1,214 @@
+package lights
+
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/core"
+)
+
+type vector3 = core.Vector3
+
+// Light is anything that lights the scene. Lights are not objects, so shadow rays are traced
+// to the end points they give rather than to the light itself
+type Light interface {
+	// Sample picks a direction the light arrives at p from using two uniform random numbers in
+	// [0, 1). It returns false if the light can't light p
+	Sample(p vector3, u1, u2 float64) (LightSample, bool)
+	// SampleCount is the number of samples to take of the light for each point it lights
+	SampleCount() int
+}
+
+// LightSample is a direction light arrives at a point p from
+type LightSample struct {
+	// Wi is the unit vector from p towards the light and Dist how far a shadow ray must go
+	// along it to reach the light, which is infinite for lights far away
+	Wi   vector3
+	Dist float64
+	// Radiance is the light leaving the light towards p
+	Radiance vector3
+	// Pdf is the density the direction was picked with, per unit solid angle. Lights that only
+	// shine from a single direction use 1, and Radiance is then the light arriving at p
+	Pdf float64
+}
+
+// pointSample returns the sample for a light at position giving off intensity towards p, which
+// falls off with the square of the distance if falloff is set
+func pointSample(p, position, intensity vector3, falloff bool) (LightSample, bool) {
+	toLight := position.Subtract(p)
+	dist := toLight.Length()
+	if dist == 0 {
+		return LightSample{}, false
+	}
+
+	if falloff {
+		intensity = intensity.Smult(1 / (dist * dist))
+	}
+	return LightSample{toLight.Smult(1 / dist), dist, intensity, 1}, true
+}
+
+// Point is a light at a single point that shines equally in every direction. Size is the
+// diameter of the bulb, which is used for soft shadows and as a sphere light when path tracing.
+// The Intensity arriving at a surface falls off with the square of the distance if Falloff is
+// set, otherwise it stays the same at any distance
+type Point struct {
+	Position  vector3
+	Intensity vector3
+	Size      float64
+	// Falloff is ignored when path tracing, where lights always fall off
+	Falloff bool
+}
+
+// NewPoint creates a point light with no falloff
+func NewPoint(position, intensity vector3, size float64) *Point {
+	return &Point{position, intensity, size, false}
+}
+
+// Sample implements the Light function
+func (l *Point) Sample(p vector3, _, _ float64) (LightSample, bool) {
+	return pointSample(p, l.Position, l.Intensity, l.Falloff)
+}
+
+// SampleCount implements the Light function
+func (l *Point) SampleCount() int {
+	return 1
+}
+
+// Directional is a light so far away that it arrives from the same direction everywhere, like
+// the sun. Direction is the way the light travels. With an AngularRadius, in radians, it is a
+// disc in the sky which casts soft shadows, with Irradiance still the total arriving at a
+// surface facing it
+type Directional struct {
+	Direction     vector3
+	Irradiance    vector3
+	AngularRadius float64
+	Samples       int
+}
+
+// NewDirectional creates a directional light
+func NewDirectional(direction, irradiance vector3, angularRadius float64, samples int) *Directional {
+	return &Directional{direction.Normalize(), irradiance, angularRadius, samples}
+}
+
+// Sample implements the Light function, picking a direction uniformly within the sun's disc
+func (l *Directional) Sample(_ vector3, u1, u2 float64) (LightSample, bool) {
+	toLight := l.Direction.Smult(-1)
+	if l.AngularRadius <= 0 {
+		return LightSample{toLight, math.Inf(1), l.Irradiance, 1}, true
+	}
+
+	// 1 - cos written so that it keeps its precision for the sun's small disc
+	s := math.Sin(l.AngularRadius / 2)
+	oneMinusCosMax := 2 * s * s
+	pdf := 1 / (2 * math.Pi * oneMinusCosMax)
+
+	// The radiance is spread evenly over the solid angle of the disc
+	wi := SampleCone(toLight, oneMinusCosMax, u1, u2)
+	return LightSample{wi, math.Inf(1), l.Irradiance.Smult(pdf), pdf}, true
+}
+
+// SampleCount implements the Light function
+func (l *Directional) SampleCount() int {
+	if l.AngularRadius <= 0 {
+		return 1
+	}
+	return samples(l.Samples)
+}
+
+// Spot is a point light that only shines within a cone around Direction. Angle is the half
+// angle of the cone in radians, and the last Blend fraction of it fades smoothly to nothing
+// to give the edge of the pool of light a soft edge
+type Spot struct {
+	Position  vector3
+	Direction vector3
+	Intensity vector3
+	Angle     float64
+	Blend     float64
+	// Falloff makes the light fall off with the square of the distance, it is ignored when path
+	// tracing, where lights always fall off
+	Falloff bool
+}
+
+// NewSpot creates a spot light
+func NewSpot(position, direction, intensity vector3, angle, blend float64, falloff bool) *Spot {
+	return &Spot{position, direction.Normalize(), intensity, angle, blend, falloff}
+}
+
+// Sample implements the Light function
+func (l *Spot) Sample(p vector3, _, _ float64) (LightSample, bool) {
+	sample, ok := pointSample(p, l.Position, l.Intensity, l.Falloff)
+	if !ok {
+		return sample, false
+	}
+
+	cosOuter := math.Cos(l.Angle)
+	cosInner := math.Cos(l.Angle * (1 - l.Blend))
+	cos := -sample.Wi.Dot(l.Direction)
+	if cos <= cosOuter {
+		return LightSample{}, false
+	}
+
+	if cos < cosInner {
+		x := (cos - cosOuter) / (cosInner - cosOuter)
+		sample.Radiance = sample.Radiance.Smult(x * x * (3 - 2*x))
+	}
+	return sample, true
+}
+
+// SampleCount implements the Light function
+func (l *Spot) SampleCount() int {
+	return 1
+}
+
+// Profile is a point light whose intensity changes with the angle from Direction, like the
+// photometric data in an IES file. Profile holds the fraction of Intensity given off at evenly
+// spaced angles from 0 along Direction to π opposite it, and is interpolated between them.
+// It is symmetric around Direction
+type Profile struct {
+	Position  vector3
+	Direction vector3
+	Intensity vector3
+	Profile   []float64
+	// Falloff makes the light fall off with the square of the distance, it is ignored when path
+	// tracing, where lights always fall off
+	Falloff bool
+}
+
+// NewProfile creates a profile light, the profile must have at least one value
+func NewProfile(position, direction, intensity vector3, profile []float64, falloff bool) *Profile {
+	return &Profile{position, direction.Normalize(), intensity, profile, falloff}
+}
+
+// scale returns the fraction of the intensity given off in the direction at angle from the
+// light's direction
+func (l *Profile) scale(angle float64) float64 {
+	if len(l.Profile) == 1 {
+		return l.Profile[0]
+	}
+
+	x := angle / math.Pi * float64(len(l.Profile)-1)
+	i := int(math.Min(x, float64(len(l.Profile)-2)))
+	t := x - float64(i)
+	return l.Profile[i]*(1-t) + l.Profile[i+1]*t
+}
+
+// Sample implements the Light function
+func (l *Profile) Sample(p vector3, _, _ float64) (LightSample, bool) {
+	sample, ok := pointSample(p, l.Position, l.Intensity, l.Falloff)
+	if !ok {
+		return sample, false
+	}
+
+	cos := math.Max(-1, math.Min(1, -sample.Wi.Dot(l.Direction)))
+	scale := l.scale(math.Acos(cos))
+	if scale <= 0 {
+		return LightSample{}, false
+	}
+
+	sample.Radiance = sample.Radiance.Smult(scale)
+	return sample, true
+}
+
+// SampleCount implements the Light function
+func (l *Profile) SampleCount() int {
+	return 1
+}
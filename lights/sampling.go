@@ -0,0 +1,65 @@
+package lights
+
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/core"
+)
+
+// ConcentricDisk maps the unit square onto the unit disk keeping areas in proportion, using
+// Shirley and Chiu's mapping so that strata stay compact
+func ConcentricDisk(u1, u2 float64) (float64, float64) {
+	a, b := 2*u1-1, 2*u2-1
+	if a == 0 && b == 0 {
+		return 0, 0
+	}
+
+	if math.Abs(a) > math.Abs(b) {
+		phi := math.Pi / 4 * (b / a)
+		return a * math.Cos(phi), a * math.Sin(phi)
+	}
+	phi := math.Pi/2 - math.Pi/4*(a/b)
+	return b * math.Cos(phi), b * math.Sin(phi)
+}
+
+// SphereCone returns the direction from p to centre and 1 - cos of the half angle of the cone
+// a sphere of radius r around centre fills as seen from p. It returns false from inside it
+func SphereCone(centre vector3, r float64, p vector3) (vector3, float64, bool) {
+	toLight := centre.Subtract(p)
+	dist2 := toLight.Dot(toLight)
+	if dist2 <= r*r {
+		return vector3{}, 0, false
+	}
+
+	sin2 := r * r / dist2
+	cosMax := math.Sqrt(1 - sin2)
+	return toLight.Normalize(), sin2 / (1 + cosMax), true
+}
+
+// SampleCone picks a direction uniformly within the cone of directions around the unit vector n
+// where cos of the angle from n is at least 1 - oneMinusCosMax. It is passed that way round as
+// it is tiny for small cones and would lose precision. The pdf is 1 / (2π oneMinusCosMax)
+func SampleCone(n vector3, oneMinusCosMax, u1, u2 float64) vector3 {
+	cosTheta := 1 - u1*oneMinusCosMax
+	sinTheta := math.Sqrt(math.Max(0, 1-cosTheta*cosTheta))
+	phi := 2 * math.Pi * u2
+
+	t, b := core.OrthonormalBasis(n)
+	return t.Smult(math.Cos(phi) * sinTheta).Add(b.Smult(math.Sin(phi) * sinTheta)).Add(n.Smult(cosTheta))
+}
+
+// Stratify returns the point for sample i of n in the unit square. The samples fill as large a
+// grid of cells as n allows and are jittered within their cell by u1 and u2, any left over are
+// spread over the whole square. The mean of all n samples is then still unbiased
+func Stratify(i, n int, u1, u2 float64) (float64, float64) {
+	cols := int(math.Sqrt(float64(n)))
+	if cols < 1 {
+		return u1, u2
+	}
+
+	rows := n / cols
+	if i >= cols*rows {
+		return u1, u2
+	}
+	return (float64(i%cols) + u1) / float64(cols), (float64(i/cols) + u2) / float64(rows)
+}
@@ -3,10 +3,16 @@ package main
 import (
 	"flag"
 	"fmt"
-	"math"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/benvardy/raytracing/core"
+	"github.com/benvardy/raytracing/lights"
 	"github.com/benvardy/raytracing/mats"
 	"github.com/benvardy/raytracing/sobjs"
 	"github.com/benvardy/raytracing/tracer"
@@ -14,46 +20,90 @@ import (
 
 type vector3 = core.Vector3
 
-func printTimeTaken(lab string, start time.Time) {
-	fmt.Printf("TIMER: %s : %v\n", lab, time.Since(start))
+// outputOptions are how output files are stored
+type outputOptions struct {
+	display        core.Display
+	exrType        core.EXRPixelType
+	exrCompression core.EXRCompression
+	// pngDepth is the bits per channel of PNG output, 8 or 16
+	pngDepth    int
+	jpegQuality int
 }
 
-func main() {
-	// Flags
-	var saveLoc string
-	flag.StringVar(&saveLoc, "save", "image.png", "The path of the image save")
-	flag.StringVar(&saveLoc, "s", "image.png", "The path of the image save (shorthand)")
+// imageWriter returns a function that writes the framebuffer in the format given by the
+// extension of fname, so an unknown format is caught before rendering. OpenEXR, Radiance and
+// PFM files keep the linear radiance, the others are tone mapped for display
+func imageWriter(fb *core.Framebuffer, fname string, out outputOptions) (func(w io.Writer) error, error) {
+	var write func(w io.Writer) error
 
-	var width, height int
-	flag.IntVar(&width, "w", 1920, "The width of the image")
-	flag.IntVar(&height, "h", 1080, "The width of the image")
+	switch ext := strings.ToLower(filepath.Ext(fname)); ext {
+	case ".png":
+		if out.pngDepth == 16 {
+			write = func(w io.Writer) error { return png.Encode(w, fb.ToImage16(out.display)) }
+		} else {
+			write = func(w io.Writer) error { return png.Encode(w, fb.ToImage(out.display).Img) }
+		}
+	case ".jpg", ".jpeg":
+		write = func(w io.Writer) error {
+			return jpeg.Encode(w, fb.ToImage(out.display).Img, &jpeg.Options{Quality: out.jpegQuality})
+		}
+	case ".ppm":
+		write = func(w io.Writer) error { return fb.ToImage(out.display).WritePPM(w) }
+	case ".bmp":
+		write = func(w io.Writer) error { return fb.ToImage(out.display).WriteBMP(w) }
+	case ".pfm":
+		write = func(w io.Writer) error { return core.WritePFM(w, fb) }
+	case ".exr":
+		write = func(w io.Writer) error { return core.WriteEXR(w, fb, out.exrType, out.exrCompression) }
+	case ".hdr":
+		write = func(w io.Writer) error { return core.WriteRGBE(w, fb) }
+	default:
+		return nil, fmt.Errorf("unknown image format %q, want .png, .jpg, .ppm, .bmp, .pfm, .exr or .hdr", ext)
+	}
 
-	var dof, nshadows bool
-	flag.BoolVar(&dof, "dof", false, "Toggle Depth of Field")
-	flag.BoolVar(&nshadows, "ns", false, "Toggle nice shadows")
-	flag.Parse()
+	return write, nil
+}
 
-	defer printTimeTaken("Ray Trace", time.Now())
+// loadCheckpoint reads the samples saved by saveCheckpoint
+func loadCheckpoint(fname string) (*tracer.Accumulation, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
 
-	img := core.NewImage(width, height)
+	return tracer.LoadAccumulation(f)
+}
 
-	base := 1920.0 * 1080.0
-	pixelWidth := 0.2 * math.Log2(base/float64(width*height))
-	// To fix if with and height are 1920*1080
-	if pixelWidth == 0 {
-		pixelWidth = 0.2
+// saveCheckpoint saves the samples taken so far to fname. It is written beside it first and
+// moved into place, so the last checkpoint survives the render being killed while saving
+func saveCheckpoint(fname string, acc *tracer.Accumulation) error {
+	tmp := fname + ".tmp"
+	if err := core.WriteFile(tmp, acc.Save); err != nil {
+		return err
 	}
 
+	return os.Rename(tmp, fname)
+}
+
+func printTimeTaken(lab string, start time.Time) {
+	fmt.Printf("TIMER: %s : %v\n", lab, time.Since(start))
+}
+
+// defaultScene builds the scene rendered when no scene file is given
+func defaultScene(width, height int) *tracer.Scene {
 	scene := tracer.NewScene(
-		vector3{-1, 0, 0}, // left
-		vector3{0, 1, 0},  // look
-		vector3{0, 0, 0},  // eye
-		150,               // grid dist
-		pixelWidth,        // pixel width
-		50,                // focal dist
-		0.6,               // aperture size
-		img.Width,
-		img.Height,
+		tracer.NewPerspective(
+			vector3{0, 0, 0}, // eye
+			vector3{0, 1, 0}, // target
+			vector3{0, 0, 1}, // up
+			71.5,             // vertical fov
+			width,
+			height,
+		),
+		tracer.NewLens(0.3, 50), // aperture radius, focus distance
+		width,
+		height,
 		vector3{0.05, 0.05, 0.05}, // ambient
 	)
 
@@ -70,14 +120,199 @@ func main() {
 	scene.AddSceneObject(sobjs.NewPlane(vector3{0, 1000, 0}, vector3{0, -1, 0}, mats.WallMaterial))
 
 	// Lights
-	scene.AddSceneLight(core.NewSceneLight(vector3{4.5, 26, -4}, vector3{.6, .6, .6}, 1))
+	scene.AddSceneLight(lights.NewPoint(vector3{4.5, 26, -4}, vector3{.6, .6, .6}, 1))
 	// Studio Lights
-	scene.AddSceneLight(core.NewSceneLight(vector3{100, -100, 30}, vector3{.3, .3, .3}, 1))
-	scene.AddSceneLight(core.NewSceneLight(vector3{-100, -100, 30}, vector3{0.3, .3, 0.3}, 1))
-	scene.AddSceneLight(core.NewSceneLight(vector3{100, 100, 30}, vector3{0.3, 0.3, .3}, 1))
-	scene.AddSceneLight(core.NewSceneLight(vector3{-100, 100, 30}, vector3{.3, .3, .3}, 1))
+	scene.AddSceneLight(lights.NewPoint(vector3{100, -100, 30}, vector3{.3, .3, .3}, 1))
+	scene.AddSceneLight(lights.NewPoint(vector3{-100, -100, 30}, vector3{0.3, .3, 0.3}, 1))
+	scene.AddSceneLight(lights.NewPoint(vector3{100, 100, 30}, vector3{0.3, 0.3, .3}, 1))
+	scene.AddSceneLight(lights.NewPoint(vector3{-100, 100, 30}, vector3{.3, .3, .3}, 1))
 
-	tracer.Trace(scene, img, dof, nshadows)
+	scene.Source = "default"
+	return scene
+}
 
-	img.PrintToFile(saveLoc)
+func main() {
+	// Flags
+	var saveLoc string
+	flag.StringVar(&saveLoc, "save", "image.png", "The path of the image save")
+	flag.StringVar(&saveLoc, "s", "image.png", "The path of the image save (shorthand)")
+
+	var heatmapLoc string
+
+	var checkpointLoc, resumeLoc string
+	flag.StringVar(&checkpointLoc, "checkpoint", "", "The path to save the samples taken so far to, so the render can be resumed")
+	flag.StringVar(&resumeLoc, "resume", "", "The path of a checkpoint to carry on rendering from, which is then saved over")
+	var interval time.Duration
+	flag.DurationVar(&interval, "interval", time.Minute, "How often to save the image and checkpoint between passes")
+
+	var sceneFile string
+	flag.StringVar(&sceneFile, "scene", "", "The path of a JSON scene description to render instead of the default scene")
+
+	var width, height int
+	flag.IntVar(&width, "w", 1920, "The width of the image")
+	flag.IntVar(&height, "h", 1080, "The width of the image")
+
+	opts := tracer.DefaultRenderOptions()
+	flag.IntVar(&opts.PixelSamples, "aa", opts.PixelSamples, "The number of samples in each pixel for anti-aliasing")
+	pattern := flag.String("pattern", opts.Pattern.String(), "The placement of the samples in each pixel: stratified, halton or sobol")
+	filter := flag.String("filter", opts.Filter.String(), "The filter weighing samples into pixels: box, tent, gaussian or mitchell")
+	flag.IntVar(&opts.Passes, "passes", opts.Passes, "The number of passes of anti-aliasing samples to take in each pixel")
+	flag.Float64Var(&opts.AdaptiveThreshold, "adaptive", 0, "The relative noise below which pixels stop taking more samples, 0 for a fixed number")
+	flag.IntVar(&opts.MaxPixelSamples, "maxaa", opts.MaxPixelSamples, "The most samples a pixel can take with adaptive sampling")
+	flag.StringVar(&heatmapLoc, "heatmap", "", "The path to save an image of the number of samples in each pixel to")
+	flag.BoolVar(&opts.DOF, "dof", false, "Toggle Depth of Field")
+	flag.IntVar(&opts.LensSamples, "lenssamples", opts.LensSamples, "The number of points on the lens each pixel is seen from with depth of field")
+	flag.BoolVar(&opts.Shading, "ns", false, "Toggle nice shadows")
+	flag.IntVar(&opts.Workers, "workers", opts.Workers, "The number of goroutines to render with")
+	flag.Int64Var(&opts.Seed, "seed", opts.Seed, "The seed for the random sampling")
+	flag.IntVar(&opts.MaxDepth, "depth", opts.MaxDepth, "The maximum number of bounces for a ray")
+	mode := flag.String("mode", opts.Mode.String(), "The renderer to use: whitted or path")
+	flag.IntVar(&opts.Samples, "spp", opts.Samples, "The number of paths traced for each sample when path tracing")
+	flag.BoolVar(&opts.RussianRoulette, "rr", false, "Toggle Russian roulette ending of rays")
+	flag.IntVar(&opts.RouletteDepth, "rrdepth", opts.RouletteDepth, "The number of bounces before Russian roulette starts")
+
+	var out outputOptions
+	toneMapper := flag.String("tonemap", out.display.ToneMapper.String(), "The tone mapper to display the image with: clamp, reinhard or aces")
+	flag.Float64Var(&out.display.Exposure, "exposure", 0, "The exposure adjustment in stops")
+	flag.IntVar(&out.pngDepth, "pngdepth", 8, "The bits per channel of PNG output: 8 or 16")
+	flag.IntVar(&out.jpegQuality, "quality", 90, "The quality of JPEG output from 1 to 100")
+
+	exrType := flag.String("exrtype", "half", "The pixel type of OpenEXR output: half or float")
+	exrCompression := flag.String("exrcompression", "zip", "The compression of OpenEXR output: none or zip")
+	flag.Parse()
+
+	var err error
+	if opts.Mode, err = tracer.ParseRenderMode(*mode); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if opts.Pattern, err = tracer.ParseSamplePattern(*pattern); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if opts.Filter, err = tracer.ParsePixelFilter(*filter); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if out.display.ToneMapper, err = core.ParseToneMapper(*toneMapper); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if out.exrType, err = core.ParseEXRPixelType(*exrType); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if out.exrCompression, err = core.ParseEXRCompression(*exrCompression); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if opts.PixelSamples < 1 {
+		fmt.Fprintf(os.Stderr, "Pixel samples must be at least 1, got %d\n", opts.PixelSamples)
+		os.Exit(1)
+	}
+	if opts.Passes < 1 {
+		fmt.Fprintf(os.Stderr, "Passes must be at least 1, got %d\n", opts.Passes)
+		os.Exit(1)
+	}
+	if opts.AdaptiveThreshold < 0 {
+		fmt.Fprintf(os.Stderr, "Adaptive threshold must not be negative, got %v\n", opts.AdaptiveThreshold)
+		os.Exit(1)
+	}
+	if opts.AdaptiveThreshold > 0 && opts.MaxPixelSamples < opts.PixelSamples {
+		fmt.Fprintf(os.Stderr, "Max pixel samples must be at least the %d pixel samples, got %d\n", opts.PixelSamples, opts.MaxPixelSamples)
+		os.Exit(1)
+	}
+	if opts.LensSamples < 1 {
+		fmt.Fprintf(os.Stderr, "Lens samples must be at least 1, got %d\n", opts.LensSamples)
+		os.Exit(1)
+	}
+	if out.pngDepth != 8 && out.pngDepth != 16 {
+		fmt.Fprintf(os.Stderr, "PNG depth must be 8 or 16, got %d\n", out.pngDepth)
+		os.Exit(1)
+	}
+	if out.jpegQuality < 1 || out.jpegQuality > 100 {
+		fmt.Fprintf(os.Stderr, "JPEG quality must be from 1 to 100, got %d\n", out.jpegQuality)
+		os.Exit(1)
+	}
+
+	defer printTimeTaken("Ray Trace", time.Now())
+
+	fb := core.NewFramebuffer(width, height)
+	write, err := imageWriter(fb, saveLoc, out)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error saving image %s: %v\n", saveLoc, err)
+		os.Exit(1)
+	}
+
+	// The heat map is saved as it is, without the display settings of the render
+	heatmap := core.NewFramebuffer(width, height)
+	var writeHeatmap func(w io.Writer) error
+	if heatmapLoc != "" {
+		heatmapOut := out
+		heatmapOut.display = core.Display{}
+		if writeHeatmap, err = imageWriter(heatmap, heatmapLoc, heatmapOut); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving heat map %s: %v\n", heatmapLoc, err)
+			os.Exit(1)
+		}
+	}
+
+	var scene *tracer.Scene
+	if sceneFile != "" {
+		if scene, err = tracer.LoadScene(sceneFile, fb.Width, fb.Height); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading scene %s: %v\n", sceneFile, err)
+			os.Exit(1)
+		}
+	} else {
+		scene = defaultScene(fb.Width, fb.Height)
+	}
+
+	acc := tracer.NewAccumulation(width, height)
+	if resumeLoc != "" {
+		if acc, err = loadCheckpoint(resumeLoc); err == nil {
+			err = acc.Compatible(scene, opts)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error resuming from %s: %v\n", resumeLoc, err)
+			os.Exit(1)
+		}
+		if checkpointLoc == "" {
+			checkpointLoc = resumeLoc
+		}
+		fmt.Printf("Resuming after pass %d\n", acc.Passes)
+	}
+
+	// The image and checkpoint are saved every interval between passes and once at the end
+	savedPasses, lastSave := -1, time.Now()
+	save := func(acc *tracer.Accumulation) {
+		acc.Resolve(fb)
+		if err := core.WriteFile(saveLoc, write); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving image %s: %v\n", saveLoc, err)
+			os.Exit(1)
+		}
+		if checkpointLoc != "" {
+			if err := saveCheckpoint(checkpointLoc, acc); err != nil {
+				fmt.Fprintf(os.Stderr, "Error saving checkpoint %s: %v\n", checkpointLoc, err)
+				os.Exit(1)
+			}
+		}
+		savedPasses, lastSave = acc.Passes, time.Now()
+	}
+
+	tracer.TraceProgressive(scene, acc, opts, func(acc *tracer.Accumulation) {
+		if time.Since(lastSave) >= interval {
+			save(acc)
+			fmt.Printf("Saved pass %d\n", acc.Passes)
+		}
+	})
+	if acc.Passes != savedPasses {
+		save(acc)
+	}
+
+	if writeHeatmap != nil {
+		tracer.SampleHeatmap(acc.Count, heatmap)
+		if err := core.WriteFile(heatmapLoc, writeHeatmap); err != nil {
+			fmt.Fprintf(os.Stderr, "Error saving heat map %s: %v\n", heatmapLoc, err)
+			os.Exit(1)
+		}
+	}
 }
@@ -0,0 +1,249 @@
+package mats
+
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/core"
+)
+
+// BSDF describes how a surface scatters light. Directions are unit vectors in the surface's
+// local frame, where the outward shading normal is +z, and both wo and wi point away from the
+// surface. wo is the direction light leaves towards the viewer and wi the one it arrives from
+type BSDF interface {
+	// Eval returns the value of the BSDF for the pair of directions. Perfectly smooth parts of the
+	// BSDF are not included as they can only be found by Sample
+	Eval(wo, wi vector3) vector3
+	// Sample picks wi for wo using three uniform random numbers in [0, 1). It returns false if
+	// the light is absorbed
+	Sample(wo vector3, u1, u2, u3 float64) (BSDFSample, bool)
+	// Pdf returns the density Sample picks wi with, again leaving out the smooth parts
+	Pdf(wo, wi vector3) float64
+}
+
+// BSDFSample is a direction picked by BSDF.Sample
+type BSDFSample struct {
+	Wi  vector3
+	F   vector3
+	Pdf float64
+	// Specular is set if Wi came from a perfectly smooth part of the BSDF, in which case F and
+	// Pdf are relative to that single direction
+	Specular bool
+}
+
+// Transmitter is implemented by BSDFs that let light through the surface
+type Transmitter interface {
+	// Transmittance returns the fraction of light that passes through
+	Transmittance() float64
+}
+
+// GetBSDF returns the BSDF of the material, made from the Phong parameters if BSDF is not set
+func (m Material) GetBSDF() BSDF {
+	if m.BSDF != nil {
+		return m.BSDF
+	}
+	return phongMaterial{m}
+}
+
+// Transmittance returns the fraction of light that passes straight through the material,
+// which is used for shadow rays
+func (m Material) Transmittance() float64 {
+	if m.BSDF == nil {
+		return m.Transmission
+	}
+	if t, ok := m.BSDF.(Transmitter); ok {
+		return t.Transmittance()
+	}
+	return 0
+}
+
+// sameHemisphere is true if a and b are on the same side of the surface
+func sameHemisphere(a, b vector3) bool {
+	return a.Z*b.Z > 0
+}
+
+// upper flips v into the same hemisphere as wo, so one sided lobes work from both sides
+func upper(v, wo vector3) vector3 {
+	if wo.Z < 0 {
+		v.Z = -v.Z
+	}
+	return v
+}
+
+// mirror reflects wo about the normal
+func mirror(wo vector3) vector3 {
+	return vector3{-wo.X, -wo.Y, wo.Z}
+}
+
+// cosineHemisphere picks a direction above the surface with pdf cos / π
+func cosineHemisphere(u1, u2 float64) vector3 {
+	r := math.Sqrt(u1)
+	phi := 2 * math.Pi * u2
+	return vector3{r * math.Cos(phi), r * math.Sin(phi), math.Sqrt(math.Max(0, 1-u1))}
+}
+
+// fromFrame turns v, given in the frame around the unit vector n where n is z, into the frame
+// the vectors are in
+func fromFrame(n, v vector3) vector3 {
+	t, b := core.OrthonormalBasis(n)
+	return t.Smult(v.X).Add(b.Smult(v.Y)).Add(n.Smult(v.Z))
+}
+
+// Lambertian is a perfectly diffuse BSDF
+type Lambertian struct {
+	Albedo vector3
+}
+
+// Eval implements the BSDF function
+func (l Lambertian) Eval(wo, wi vector3) vector3 {
+	if !sameHemisphere(wo, wi) {
+		return vector3{}
+	}
+	return l.Albedo.Smult(1 / math.Pi)
+}
+
+// Sample implements the BSDF function with cosine weighted sampling
+func (l Lambertian) Sample(wo vector3, u1, u2, _ float64) (BSDFSample, bool) {
+	if wo.Z == 0 {
+		return BSDFSample{}, false
+	}
+
+	wi := upper(cosineHemisphere(u1, u2), wo)
+	pdf := l.Pdf(wo, wi)
+	if pdf <= 0 {
+		return BSDFSample{}, false
+	}
+	return BSDFSample{Wi: wi, F: l.Eval(wo, wi), Pdf: pdf}, true
+}
+
+// Pdf implements the BSDF function
+func (l Lambertian) Pdf(wo, wi vector3) float64 {
+	if !sameHemisphere(wo, wi) {
+		return 0
+	}
+	return math.Abs(wi.Z) / math.Pi
+}
+
+// Phong is a Lambertian diffuse lobe with Kd plus a normalised modified Phong glossy lobe with Ks,
+// where Exponent controls the size of the highlight
+type Phong struct {
+	Kd, Ks   vector3
+	Exponent float64
+}
+
+// lobes returns the chance of sampling the diffuse lobe rather than the glossy one, in
+// proportion to how bright each is. It returns false if both are black
+func (p Phong) lobes() (float64, bool) {
+	diffuse, glossy := core.Luminance(p.Kd), core.Luminance(p.Ks)
+	if diffuse+glossy <= 0 {
+		return 0, false
+	}
+	return diffuse / (diffuse + glossy), true
+}
+
+// Eval implements the BSDF function
+func (p Phong) Eval(wo, wi vector3) vector3 {
+	if !sameHemisphere(wo, wi) {
+		return vector3{}
+	}
+
+	cosAlpha := math.Pow(math.Max(0, mirror(wo).Dot(wi)), p.Exponent)
+	return p.Kd.Smult(1 / math.Pi).Add(p.Ks.Smult((p.Exponent + 2) / (2 * math.Pi) * cosAlpha))
+}
+
+// Sample implements the BSDF function, picking the diffuse or glossy lobe with u3
+func (p Phong) Sample(wo vector3, u1, u2, u3 float64) (BSDFSample, bool) {
+	pDiffuse, ok := p.lobes()
+	if !ok || wo.Z == 0 {
+		return BSDFSample{}, false
+	}
+
+	var wi vector3
+	if u3 < pDiffuse {
+		wi = upper(cosineHemisphere(u1, u2), wo)
+	} else {
+		// Sample around the mirror direction with cos^(exp) falloff
+		cosAlpha := math.Pow(u1, 1/(p.Exponent+1))
+		sinAlpha := math.Sqrt(math.Max(0, 1-cosAlpha*cosAlpha))
+		phi := 2 * math.Pi * u2
+		wi = fromFrame(mirror(wo), vector3{math.Cos(phi) * sinAlpha, math.Sin(phi) * sinAlpha, cosAlpha})
+	}
+
+	pdf := p.Pdf(wo, wi)
+	if pdf <= 0 {
+		return BSDFSample{}, false
+	}
+	return BSDFSample{Wi: wi, F: p.Eval(wo, wi), Pdf: pdf}, true
+}
+
+// Pdf implements the BSDF function
+func (p Phong) Pdf(wo, wi vector3) float64 {
+	pDiffuse, ok := p.lobes()
+	if !ok || !sameHemisphere(wo, wi) {
+		return 0
+	}
+
+	cosAlpha := math.Pow(math.Max(0, mirror(wo).Dot(wi)), p.Exponent)
+	return pDiffuse*math.Abs(wi.Z)/math.Pi + (1-pDiffuse)*(p.Exponent+1)/(2*math.Pi)*cosAlpha
+}
+
+// phongMaterial is the BSDF of a Material without one set. The Transmission part is a smooth
+// dielectric, the Reflectivity part of what is left is a perfect mirror, and the rest is Phong
+type phongMaterial struct {
+	m Material
+}
+
+// weights returns the chance of light being transmitted, mirrored or scattered by Phong
+func (p phongMaterial) weights() (float64, float64, float64) {
+	t := p.m.Transmission
+	r := (1 - t) * p.m.Reflectivity
+	return t, r, 1 - t - r
+}
+
+func (p phongMaterial) phong() Phong {
+	return Phong{p.m.Kd, p.m.Ks, p.m.Roughness}
+}
+
+// Eval implements the BSDF function
+func (p phongMaterial) Eval(wo, wi vector3) vector3 {
+	_, _, smooth := p.weights()
+	return p.phong().Eval(wo, wi).Smult(smooth)
+}
+
+// Sample implements the BSDF function, picking a part with u3 and reusing it within the part
+func (p phongMaterial) Sample(wo vector3, u1, u2, u3 float64) (BSDFSample, bool) {
+	t, r, smooth := p.weights()
+
+	switch {
+	case u3 < t:
+		ior := p.m.IOR
+		if ior == 0 {
+			ior = 1
+		}
+		return Dielectric{IOR: ior}.Sample(wo, u1, u2, u3/t)
+	case u3 < t+r:
+		wi := mirror(wo)
+		return BSDFSample{Wi: wi, F: white.Smult(1 / math.Abs(wi.Z)), Pdf: 1, Specular: true}, true
+	}
+
+	s, ok := p.phong().Sample(wo, u1, u2, (u3-t-r)/smooth)
+	if !ok {
+		return s, false
+	}
+
+	s.F = s.F.Smult(smooth)
+	s.Pdf *= smooth
+	return s, true
+}
+
+// Pdf implements the BSDF function
+func (p phongMaterial) Pdf(wo, wi vector3) float64 {
+	_, _, smooth := p.weights()
+	return p.phong().Pdf(wo, wi) * smooth
+}
+
+// Transmittance implements Transmitter
+func (p phongMaterial) Transmittance() float64 {
+	return p.m.Transmission
+}
+
+var white = vector3{1, 1, 1}
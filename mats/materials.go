@@ -9,6 +9,85 @@ type Material struct {
 	Ka, Kd, Ks   vector3
 	Roughness    float64
 	Reflectivity float64
+
+	// IOR is the index of refraction, 0 is treated as 1
+	IOR float64
+	// Transmission is the fraction of light that passes into the material rather than being
+	// shaded at the surface
+	Transmission float64
+	// Absorption is how much of each colour is absorbed per unit distance travelled inside the
+	// material, following the Beer–Lambert law
+	Absorption vector3
+
+	// BSDF, if set, is used to shade the material in place of the Phong parameters. Ka and
+	// Absorption still apply
+	BSDF BSDF
+
+	// Textures vary the parameters over the surface, each one that is set multiplies the value
+	// above. Kd also tints the colour of a BSDF, and the scalar maps use the red channel
+	KdMap, KsMap                  Texture
+	ReflectivityMap, RoughnessMap Texture
+
+	// NormalMap gives the shading normal in tangent space, with red along U, green against V
+	// and blue out of the surface, each mapped from [-1, 1] to [0, 1]. BumpMap is used instead
+	// if there is no NormalMap, as a height along the normal in its red channel scaled by
+	// BumpScale
+	NormalMap, BumpMap Texture
+	BumpScale          float64
+
+	// Emission is the radiance given off by the front of the surface, which makes objects
+	// with the material lights. EmissionSamples is the number of shadow rays taken of each
+	// one for every point it lights
+	Emission        vector3
+	EmissionSamples int
+}
+
+// Emissive is true if the material gives off light
+func (m Material) Emissive() bool {
+	return m.Emission != (vector3{})
+}
+
+// At returns the material at surface coordinates (u, v) and point p with its textures applied
+func (m Material) At(u, v float64, p vector3) Material {
+	if m.KdMap == nil && m.KsMap == nil && m.ReflectivityMap == nil && m.RoughnessMap == nil {
+		return m
+	}
+
+	colour, roughness := white, 1.0
+	if m.KdMap != nil {
+		colour = m.KdMap.Value(u, v, p)
+		m.Kd = m.Kd.Mult(colour)
+	}
+	if m.KsMap != nil {
+		m.Ks = m.Ks.Mult(m.KsMap.Value(u, v, p))
+	}
+	if m.ReflectivityMap != nil {
+		m.Reflectivity *= m.ReflectivityMap.Value(u, v, p).X
+	}
+	if m.RoughnessMap != nil {
+		roughness = m.RoughnessMap.Value(u, v, p).X
+		m.Roughness *= roughness
+	}
+
+	switch b := m.BSDF.(type) {
+	case Lambertian:
+		b.Albedo = b.Albedo.Mult(colour)
+		m.BSDF = b
+	case Conductor:
+		b.F0 = b.F0.Mult(colour)
+		b.Roughness *= roughness
+		m.BSDF = b
+	case Dielectric:
+		b.Tint = b.tint().Mult(colour)
+		b.Roughness *= roughness
+		m.BSDF = b
+	case Principled:
+		b.BaseColor = b.BaseColor.Mult(colour)
+		b.Roughness *= roughness
+		m.BSDF = b
+	}
+
+	return m
 }
 
 var standardAmbient = vector3{0.1, 0.1, 0}
@@ -44,3 +123,19 @@ var Ball3 = Material{
 	Roughness:    0.8,
 	Reflectivity: 0.1,
 }
+
+var Glass = Material{
+	Ks:           vector3{.8, .8, .8},
+	Roughness:    200,
+	IOR:          1.5,
+	Transmission: 1,
+	Absorption:   vector3{0.02, 0.01, 0.02},
+}
+
+var Water = Material{
+	Ks:           vector3{.5, .5, .5},
+	Roughness:    100,
+	IOR:          1.33,
+	Transmission: 1,
+	Absorption:   vector3{0.08, 0.02, 0.01},
+}
@@ -0,0 +1,349 @@
+package mats
+
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/core"
+)
+
+// Below this the microfacet BSDFs are treated as perfectly smooth
+const smoothAlpha = 1e-3
+
+// ggx is the Trowbridge-Reitz microfacet distribution with width alpha
+type ggx struct {
+	alpha float64
+}
+
+// newGGX maps a perceptual roughness in [0, 1] to the distribution, squaring it so that the
+// change in look is more even
+func newGGX(roughness float64) ggx {
+	r := math.Max(0, math.Min(1, roughness))
+	return ggx{r * r}
+}
+
+func (g ggx) smooth() bool {
+	return g.alpha < smoothAlpha
+}
+
+// d is the density of microfacets with normal m
+func (g ggx) d(m vector3) float64 {
+	if m.Z <= 0 {
+		return 0
+	}
+
+	a2 := g.alpha * g.alpha
+	t := m.Z*m.Z*(a2-1) + 1
+	return a2 / (math.Pi * t * t)
+}
+
+// lambda is the Smith shadowing auxiliary function for direction w
+func (g ggx) lambda(w vector3) float64 {
+	cos2 := w.Z * w.Z
+	if cos2 == 0 {
+		return math.Inf(1)
+	}
+
+	tan2 := (1 - cos2) / cos2
+	return (math.Sqrt(1+g.alpha*g.alpha*tan2) - 1) / 2
+}
+
+// g1 is the fraction of microfacets visible from w
+func (g ggx) g1(w vector3) float64 {
+	return 1 / (1 + g.lambda(w))
+}
+
+// g is the fraction of microfacets visible from both wo and wi
+func (g ggx) g(wo, wi vector3) float64 {
+	return 1 / (1 + g.lambda(wo) + g.lambda(wi))
+}
+
+// visibleD is the density of microfacet normals m seen from w, which sampleVisible picks from
+func (g ggx) visibleD(w, m vector3) float64 {
+	if w.Z == 0 {
+		return 0
+	}
+	return g.g1(w) / math.Abs(w.Z) * g.d(m) * math.Abs(w.Dot(m))
+}
+
+// sampleVisible picks a microfacet normal visible from w, following Heitz's 2018 method
+func (g ggx) sampleVisible(w vector3, u1, u2 float64) vector3 {
+	// Work with w above the surface and stretch so the distribution is a hemisphere
+	if w.Z < 0 {
+		w = w.Smult(-1)
+	}
+	vh := vector3{g.alpha * w.X, g.alpha * w.Y, w.Z}.Normalize()
+
+	t1 := vector3{1, 0, 0}
+	if lensq := vh.X*vh.X + vh.Y*vh.Y; lensq > 0 {
+		t1 = vector3{-vh.Y, vh.X, 0}.Smult(1 / math.Sqrt(lensq))
+	}
+	t2 := vh.Cross(t1)
+
+	r := math.Sqrt(u1)
+	phi := 2 * math.Pi * u2
+	p1 := r * math.Cos(phi)
+	p2 := r * math.Sin(phi)
+	s := 0.5 * (1 + vh.Z)
+	p2 = (1-s)*math.Sqrt(math.Max(0, 1-p1*p1)) + s*p2
+
+	nh := t1.Smult(p1).Add(t2.Smult(p2)).Add(vh.Smult(math.Sqrt(math.Max(0, 1-p1*p1-p2*p2))))
+	return vector3{g.alpha * nh.X, g.alpha * nh.Y, math.Max(1e-9, nh.Z)}.Normalize()
+}
+
+// schlickF is Schlick's approximation of the Fresnel reflectance for reflectance f0 head on
+func schlickF(f0 vector3, cos float64) vector3 {
+	x := 1 - math.Max(0, math.Min(1, cos))
+	x5 := x * x * x * x * x
+	return f0.Add(white.Subtract(f0).Smult(x5))
+}
+
+// fresnelDielectric is the exact Fresnel reflectance of unpolarised light at a boundary where
+// eta is the index inside over the index outside and cosI is measured from the outward normal
+func fresnelDielectric(cosI, eta float64) float64 {
+	cosI = math.Max(-1, math.Min(1, cosI))
+	if cosI < 0 {
+		eta = 1 / eta
+		cosI = -cosI
+	}
+
+	sin2T := (1 - cosI*cosI) / (eta * eta)
+	if sin2T >= 1 {
+		return 1
+	}
+	cosT := math.Sqrt(1 - sin2T)
+
+	rParl := (eta*cosI - cosT) / (eta*cosI + cosT)
+	rPerp := (cosI - eta*cosT) / (cosI + eta*cosT)
+	return (rParl*rParl + rPerp*rPerp) / 2
+}
+
+// refractDir bends wi through a surface with normal n, where eta is the index on the other side
+// over the index on the side of n. It returns the direction and the ratio of indices it used
+func refractDir(wi, n vector3, eta float64) (vector3, float64, bool) {
+	cosI := n.Dot(wi)
+	if cosI < 0 {
+		eta = 1 / eta
+		cosI = -cosI
+		n = n.Smult(-1)
+	}
+
+	sin2T := math.Max(0, 1-cosI*cosI) / (eta * eta)
+	if sin2T >= 1 {
+		return vector3{}, 0, false
+	}
+	cosT := math.Sqrt(1 - sin2T)
+
+	return wi.Smult(-1 / eta).Add(n.Smult(cosI/eta - cosT)), eta, true
+}
+
+// Conductor is a metal with GGX microfacets. F0 is its colour looking straight on and Roughness
+// in [0, 1] blurs the reflection, 0 is a perfect mirror
+type Conductor struct {
+	F0        vector3
+	Roughness float64
+}
+
+// Eval implements the BSDF function
+func (c Conductor) Eval(wo, wi vector3) vector3 {
+	g := newGGX(c.Roughness)
+	if g.smooth() || !sameHemisphere(wo, wi) {
+		return vector3{}
+	}
+
+	// Work above the surface, the metal looks the same from both sides
+	if wo.Z < 0 {
+		wo.Z, wi.Z = -wo.Z, -wi.Z
+	}
+
+	m := wo.Add(wi).Normalize()
+	f := schlickF(c.F0, wo.Dot(m))
+	return f.Smult(g.d(m) * g.g(wo, wi) / (4 * wo.Z * wi.Z))
+}
+
+// Sample implements the BSDF function
+func (c Conductor) Sample(wo vector3, u1, u2, _ float64) (BSDFSample, bool) {
+	if wo.Z == 0 {
+		return BSDFSample{}, false
+	}
+
+	g := newGGX(c.Roughness)
+	if g.smooth() {
+		wi := mirror(wo)
+		f := schlickF(c.F0, math.Abs(wo.Z)).Smult(1 / math.Abs(wi.Z))
+		return BSDFSample{Wi: wi, F: f, Pdf: 1, Specular: true}, true
+	}
+
+	up := upper(wo, wo)
+	m := g.sampleVisible(up, u1, u2)
+	wi := upper(reflectAbout(up, m), wo)
+	if !sameHemisphere(wo, wi) {
+		return BSDFSample{}, false
+	}
+
+	pdf := c.Pdf(wo, wi)
+	if pdf <= 0 {
+		return BSDFSample{}, false
+	}
+	return BSDFSample{Wi: wi, F: c.Eval(wo, wi), Pdf: pdf}, true
+}
+
+// Pdf implements the BSDF function
+func (c Conductor) Pdf(wo, wi vector3) float64 {
+	g := newGGX(c.Roughness)
+	if g.smooth() || !sameHemisphere(wo, wi) {
+		return 0
+	}
+
+	if wo.Z < 0 {
+		wo.Z, wi.Z = -wo.Z, -wi.Z
+	}
+
+	m := wo.Add(wi).Normalize()
+	return g.visibleD(wo, m) / (4 * math.Abs(wo.Dot(m)))
+}
+
+// reflectAbout mirrors w about the unit vector m
+func reflectAbout(w, m vector3) vector3 {
+	return m.Smult(2 * w.Dot(m)).Subtract(w)
+}
+
+// Dielectric is a transparent material like glass with GGX microfacets, following Walter et al.
+// IOR is the index of refraction inside, Tint filters the light passing through and Roughness
+// in [0, 1] frosts the surface, 0 is perfectly smooth
+type Dielectric struct {
+	IOR       float64
+	Roughness float64
+	// Tint defaults to white if left as black
+	Tint vector3
+}
+
+func (d Dielectric) tint() vector3 {
+	if d.Tint == (vector3{}) {
+		return white
+	}
+	return d.Tint
+}
+
+// halfVector returns the microfacet normal that scatters wo into wi facing outwards, along with
+// the ratio of indices etap for refraction, or 1 for reflection
+func (d Dielectric) halfVector(wo, wi vector3) (vector3, float64, bool) {
+	etap := 1.0
+	if !sameHemisphere(wo, wi) {
+		etap = d.IOR
+		if wo.Z < 0 {
+			etap = 1 / d.IOR
+		}
+	}
+
+	m := wi.Smult(etap).Add(wo)
+	if m == (vector3{}) || wo.Z == 0 || wi.Z == 0 {
+		return vector3{}, 0, false
+	}
+	m = m.Normalize()
+	if m.Z < 0 {
+		m = m.Smult(-1)
+	}
+
+	// Microfacets facing away from either direction can't scatter between them
+	if m.Dot(wi)*wi.Z < 0 || m.Dot(wo)*wo.Z < 0 {
+		return vector3{}, 0, false
+	}
+	return m, etap, true
+}
+
+// Eval implements the BSDF function
+func (d Dielectric) Eval(wo, wi vector3) vector3 {
+	g := newGGX(d.Roughness)
+	if g.smooth() || d.IOR == 1 {
+		return vector3{}
+	}
+
+	m, etap, ok := d.halfVector(wo, wi)
+	if !ok {
+		return vector3{}
+	}
+
+	F := fresnelDielectric(wo.Dot(m), d.IOR)
+	if etap == 1 {
+		return white.Smult(g.d(m) * g.g(wo, wi) * F / math.Abs(4*wi.Z*wo.Z))
+	}
+
+	denom := wi.Dot(m) + wo.Dot(m)/etap
+	denom = denom * denom * wi.Z * wo.Z
+	ft := g.d(m) * (1 - F) * g.g(wo, wi) * math.Abs(wi.Dot(m)*wo.Dot(m)/denom)
+
+	// Radiance is compressed into a smaller solid angle entering a denser medium
+	return d.tint().Smult(ft / (etap * etap))
+}
+
+// Sample implements the BSDF function, choosing reflection or refraction with u3
+func (d Dielectric) Sample(wo vector3, u1, u2, u3 float64) (BSDFSample, bool) {
+	if wo.Z == 0 {
+		return BSDFSample{}, false
+	}
+
+	g := newGGX(d.Roughness)
+	if g.smooth() || d.IOR == 1 {
+		R := fresnelDielectric(wo.Z, d.IOR)
+		if u3 < R {
+			wi := mirror(wo)
+			return BSDFSample{Wi: wi, F: white.Smult(R / math.Abs(wi.Z)), Pdf: R, Specular: true}, true
+		}
+
+		wi, etap, ok := refractDir(wo, vector3{0, 0, 1}, d.IOR)
+		if !ok {
+			return BSDFSample{}, false
+		}
+		f := d.tint().Smult((1 - R) / math.Abs(wi.Z) / (etap * etap))
+		return BSDFSample{Wi: wi, F: f, Pdf: 1 - R, Specular: true}, true
+	}
+
+	m := g.sampleVisible(wo, u1, u2)
+	R := fresnelDielectric(wo.Dot(m), d.IOR)
+
+	var wi vector3
+	if u3 < R {
+		wi = reflectAbout(wo, m)
+		if !sameHemisphere(wo, wi) {
+			return BSDFSample{}, false
+		}
+	} else {
+		var ok bool
+		if wi, _, ok = refractDir(wo, m, d.IOR); !ok || sameHemisphere(wo, wi) || wi.Z == 0 {
+			return BSDFSample{}, false
+		}
+	}
+
+	pdf := d.Pdf(wo, wi)
+	if pdf <= 0 {
+		return BSDFSample{}, false
+	}
+	return BSDFSample{Wi: wi, F: d.Eval(wo, wi), Pdf: pdf}, true
+}
+
+// Pdf implements the BSDF function
+func (d Dielectric) Pdf(wo, wi vector3) float64 {
+	g := newGGX(d.Roughness)
+	if g.smooth() || d.IOR == 1 {
+		return 0
+	}
+
+	m, etap, ok := d.halfVector(wo, wi)
+	if !ok {
+		return 0
+	}
+
+	R := fresnelDielectric(wo.Dot(m), d.IOR)
+	if etap == 1 {
+		return g.visibleD(wo, m) / (4 * math.Abs(wo.Dot(m))) * R
+	}
+
+	denom := wi.Dot(m) + wo.Dot(m)/etap
+	dmdwi := math.Abs(wi.Dot(m)) / (denom * denom)
+	return g.visibleD(wo, m) * dmdwi * (1 - R)
+}
+
+// Transmittance implements Transmitter
+func (d Dielectric) Transmittance() float64 {
+	return core.Luminance(d.tint())
+}
@@ -0,0 +1,86 @@
+package mats
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Perlin generates gradient noise as described in Ken Perlin's improved noise paper
+type Perlin struct {
+	perm [512]int
+}
+
+// NewPerlin creates a noise generator, the same seed always gives the same noise
+func NewPerlin(seed int64) *Perlin {
+	p := &Perlin{}
+	order := rand.New(rand.NewSource(seed)).Perm(256)
+	for i := range p.perm {
+		p.perm[i] = order[i%256]
+	}
+	return p
+}
+
+func fade(t float64) float64 {
+	return t * t * t * (t*(t*6-15) + 10)
+}
+
+func lerp(t, a, b float64) float64 {
+	return a + t*(b-a)
+}
+
+// grad returns the dot product of (x, y, z) with one of 12 gradient directions picked by hash
+func grad(hash int, x, y, z float64) float64 {
+	h := hash & 15
+	u, v := y, z
+	if h < 8 {
+		u = x
+	}
+	if h < 4 {
+		v = y
+	} else if h == 12 || h == 14 {
+		v = x
+	}
+
+	if h&1 != 0 {
+		u = -u
+	}
+	if h&2 != 0 {
+		v = -v
+	}
+	return u + v
+}
+
+// Noise returns the noise at p, which varies smoothly in about [-1, 1] with features about a
+// unit apart
+func (p *Perlin) Noise(pos vector3) float64 {
+	fx, fy, fz := math.Floor(pos.X), math.Floor(pos.Y), math.Floor(pos.Z)
+	X, Y, Z := int(fx)&255, int(fy)&255, int(fz)&255
+	x, y, z := pos.X-fx, pos.Y-fy, pos.Z-fz
+	u, v, w := fade(x), fade(y), fade(z)
+
+	perm := &p.perm
+	A := perm[X] + Y
+	AA, AB := perm[A]+Z, perm[A+1]+Z
+	B := perm[X+1] + Y
+	BA, BB := perm[B]+Z, perm[B+1]+Z
+
+	return lerp(w,
+		lerp(v,
+			lerp(u, grad(perm[AA], x, y, z), grad(perm[BA], x-1, y, z)),
+			lerp(u, grad(perm[AB], x, y-1, z), grad(perm[BB], x-1, y-1, z))),
+		lerp(v,
+			lerp(u, grad(perm[AA+1], x, y, z-1), grad(perm[BA+1], x-1, y, z-1)),
+			lerp(u, grad(perm[AB+1], x, y-1, z-1), grad(perm[BB+1], x-1, y-1, z-1))))
+}
+
+// Turbulence sums the magnitude of octaves of noise at p, each twice the frequency and half the
+// strength of the last
+func (p *Perlin) Turbulence(pos vector3, octaves int) float64 {
+	sum, weight := 0.0, 1.0
+	for i := 0; i < octaves; i++ {
+		sum += weight * math.Abs(p.Noise(pos))
+		weight /= 2
+		pos = pos.Smult(2)
+	}
+	return sum
+}
@@ -0,0 +1,127 @@
+package mats
+
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/core"
+)
+
+// Principled is a metallic-roughness material in the style of glTF. Dielectric surfaces are a
+// diffuse BaseColor under a clear GGX coat, metals reflect BaseColor, and Transmission turns the
+// diffuse part into rough glass tinted by BaseColor
+type Principled struct {
+	BaseColor    vector3
+	Metallic     float64
+	Roughness    float64
+	Transmission float64
+	// IOR is used for the coat and transmission, 0 is treated as 1.5
+	IOR float64
+}
+
+// lobe is one part of a mixed BSDF, scaled by weight and sampled with chance pick
+type lobe struct {
+	bsdf   BSDF
+	weight float64
+	pick   float64
+}
+
+func (p Principled) ior() float64 {
+	if p.IOR == 0 {
+		return 1.5
+	}
+	return p.IOR
+}
+
+// lobes splits the material into diffuse, specular and transmission lobes seen from wo, with the
+// chance of sampling each in proportion to how much light it is expected to return
+func (p Principled) lobes(wo vector3) [3]lobe {
+	metallic := math.Max(0, math.Min(1, p.Metallic))
+	transmission := math.Max(0, math.Min(1, p.Transmission)) * (1 - metallic)
+
+	r0 := (p.ior() - 1) / (p.ior() + 1)
+	r0 *= r0
+	f0 := vector3{r0, r0, r0}.Smult(1 - metallic).Add(p.BaseColor.Smult(metallic))
+
+	// The coat reflects some light before it reaches the diffuse base
+	coat := schlickF(vector3{r0, r0, r0}, math.Abs(wo.Z)).X
+	diffuse := Lambertian{p.BaseColor.Smult((1 - metallic) * (1 - coat))}
+	specular := Conductor{f0, p.Roughness}
+	glass := Dielectric{p.ior(), p.Roughness, p.BaseColor}
+
+	l := [3]lobe{
+		{diffuse, 1 - transmission, core.Luminance(diffuse.Albedo) * (1 - transmission)},
+		{specular, 1 - transmission, core.Luminance(schlickF(f0, math.Abs(wo.Z))) * (1 - transmission)},
+		{glass, transmission, transmission},
+	}
+
+	total := l[0].pick + l[1].pick + l[2].pick
+	for i := range l {
+		if total > 0 {
+			l[i].pick /= total
+		}
+	}
+	return l
+}
+
+// Eval implements the BSDF function
+func (p Principled) Eval(wo, wi vector3) vector3 {
+	f := vector3{}
+	for _, l := range p.lobes(wo) {
+		if l.weight > 0 {
+			f = f.Add(l.bsdf.Eval(wo, wi).Smult(l.weight))
+		}
+	}
+	return f
+}
+
+// Sample implements the BSDF function, picking a lobe with u3 and reusing it within the lobe
+func (p Principled) Sample(wo vector3, u1, u2, u3 float64) (BSDFSample, bool) {
+	lobes := p.lobes(wo)
+
+	chosen := -1
+	for i, l := range lobes {
+		if l.pick <= 0 {
+			continue
+		}
+		if u3 < l.pick || i == len(lobes)-1 {
+			chosen = i
+			break
+		}
+		u3 -= l.pick
+	}
+	if chosen < 0 {
+		return BSDFSample{}, false
+	}
+
+	l := lobes[chosen]
+	s, ok := l.bsdf.Sample(wo, u1, u2, math.Min(u3/l.pick, 1-1e-12))
+	if !ok {
+		return s, false
+	}
+
+	// Smooth lobes can't be reached by the others so only their own share counts
+	if s.Specular {
+		s.F = s.F.Smult(l.weight)
+		s.Pdf *= l.pick
+		return s, true
+	}
+
+	s.F, s.Pdf = p.Eval(wo, s.Wi), p.Pdf(wo, s.Wi)
+	return s, s.Pdf > 0
+}
+
+// Pdf implements the BSDF function
+func (p Principled) Pdf(wo, wi vector3) float64 {
+	pdf := 0.0
+	for _, l := range p.lobes(wo) {
+		if l.pick > 0 {
+			pdf += l.pick * l.bsdf.Pdf(wo, wi)
+		}
+	}
+	return pdf
+}
+
+// Transmittance implements Transmitter
+func (p Principled) Transmittance() float64 {
+	return math.Max(0, math.Min(1, p.Transmission)) * (1 - math.Max(0, math.Min(1, p.Metallic))) * core.Luminance(p.BaseColor)
+}
@@ -0,0 +1,201 @@
+package mats
+
+import (
+	"fmt"
+	"image"
+	// Register the formats image textures can be loaded from
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+	"os"
+
+	"github.com/benvardy/raytracing/core"
+)
+
+// Texture is a colour that varies over a surface. u and v are the surface coordinates of the
+// point p, image textures use the former and solid procedural textures the latter
+type Texture interface {
+	Value(u, v float64, p vector3) vector3
+}
+
+// Constant is a texture with the same colour everywhere
+type Constant struct {
+	Color vector3
+}
+
+// Value implements the Texture function
+func (c Constant) Value(_, _ float64, _ vector3) vector3 {
+	return c.Color
+}
+
+// WrapMode is how an image texture is extended outside [0, 1]
+type WrapMode int
+
+const (
+	// WrapRepeat tiles the image
+	WrapRepeat WrapMode = iota
+	// WrapClamp repeats the edge pixels
+	WrapClamp
+	// WrapMirror tiles the image, flipping every other copy so the edges meet
+	WrapMirror
+)
+
+// ParseWrapMode returns the WrapMode called name
+func ParseWrapMode(name string) (WrapMode, error) {
+	switch name {
+	case "repeat":
+		return WrapRepeat, nil
+	case "clamp":
+		return WrapClamp, nil
+	case "mirror":
+		return WrapMirror, nil
+	}
+	return 0, fmt.Errorf("unknown wrap mode %q, want repeat, clamp or mirror", name)
+}
+
+// wrap maps the pixel index i into [0, n)
+func (w WrapMode) wrap(i, n int) int {
+	switch w {
+	case WrapClamp:
+		if i < 0 {
+			return 0
+		}
+		if i >= n {
+			return n - 1
+		}
+		return i
+	case WrapMirror:
+		i = ((i % (2 * n)) + 2*n) % (2 * n)
+		if i >= n {
+			return 2*n - 1 - i
+		}
+		return i
+	}
+	return ((i % n) + n) % n
+}
+
+// ImageTexture maps an image onto the surface coordinates, with the top left of the image at
+// (0, 0) and the bottom right at (1, 1). Pixels are blended with bilinear filtering
+type ImageTexture struct {
+	width, height int
+	pixels        []vector3
+
+	Wrap WrapMode
+	// Scale is how many times the image repeats per unit of u and v, 0 is treated as 1
+	Scale float64
+}
+
+// LoadImageTexture reads a PNG or JPEG image. Colour images are usually stored with the sRGB
+// gamma, which srgb removes, while images holding data like roughness should be read as is
+func LoadImageTexture(fname string, wrap WrapMode, srgb bool) (*ImageTexture, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+
+	return NewImageTexture(img, wrap, srgb), nil
+}
+
+// NewImageTexture creates a texture from img, see LoadImageTexture
+func NewImageTexture(img image.Image, wrap WrapMode, srgb bool) *ImageTexture {
+	bounds := img.Bounds()
+	t := &ImageTexture{
+		width:  bounds.Dx(),
+		height: bounds.Dy(),
+		pixels: make([]vector3, bounds.Dx()*bounds.Dy()),
+		Wrap:   wrap,
+	}
+
+	decode := func(c uint32) float64 {
+		x := float64(c) / 0xffff
+		if srgb {
+			return core.SRGBDecode(x)
+		}
+		return x
+	}
+
+	for y := 0; y < t.height; y++ {
+		for x := 0; x < t.width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			t.pixels[y*t.width+x] = vector3{X: decode(r), Y: decode(g), Z: decode(b)}
+		}
+	}
+
+	return t
+}
+
+func (t *ImageTexture) pixel(x, y int) vector3 {
+	return t.pixels[t.Wrap.wrap(y, t.height)*t.width+t.Wrap.wrap(x, t.width)]
+}
+
+// Value implements the Texture function
+func (t *ImageTexture) Value(u, v float64, _ vector3) vector3 {
+	if t.width == 0 || t.height == 0 {
+		return vector3{}
+	}
+
+	scale := t.Scale
+	if scale == 0 {
+		scale = 1
+	}
+
+	// Pixel centres are at half integers
+	x := u*scale*float64(t.width) - 0.5
+	y := v*scale*float64(t.height) - 0.5
+	x0, y0 := math.Floor(x), math.Floor(y)
+	fx, fy := x-x0, y-y0
+	ix, iy := int(x0), int(y0)
+
+	top := t.pixel(ix, iy).Smult(1 - fx).Add(t.pixel(ix+1, iy).Smult(fx))
+	bottom := t.pixel(ix, iy+1).Smult(1 - fx).Add(t.pixel(ix+1, iy+1).Smult(fx))
+	return top.Smult(1 - fy).Add(bottom.Smult(fy))
+}
+
+// Checker alternates between two textures in squares of surface coordinates, Scale squares to a
+// unit of u and v
+type Checker struct {
+	Even, Odd Texture
+	Scale     float64
+}
+
+// Value implements the Texture function
+func (c Checker) Value(u, v float64, p vector3) vector3 {
+	if (int(math.Floor(u*c.Scale))+int(math.Floor(v*c.Scale)))%2 == 0 {
+		return c.Even.Value(u, v, p)
+	}
+	return c.Odd.Value(u, v, p)
+}
+
+// Noise is Perlin noise scaled to [0, 1] times Color. Scale is the number of features per unit
+// distance in the scene
+type Noise struct {
+	Perlin *Perlin
+	Color  vector3
+	Scale  float64
+}
+
+// Value implements the Texture function
+func (n Noise) Value(_, _ float64, p vector3) vector3 {
+	return n.Color.Smult(0.5 * (1 + n.Perlin.Noise(p.Smult(n.Scale))))
+}
+
+// Marble blends between A and B in veins along the z axis, made irregular by turbulence.
+// Scale is how closely the veins are packed and Turbulence how much they are distorted
+type Marble struct {
+	Perlin     *Perlin
+	A, B       vector3
+	Scale      float64
+	Turbulence float64
+}
+
+// Value implements the Texture function
+func (m Marble) Value(_, _ float64, p vector3) vector3 {
+	t := 0.5 * (1 + math.Sin(m.Scale*p.Z+m.Turbulence*m.Perlin.Turbulence(p, 7)))
+	return m.A.Smult(1 - t).Add(m.B.Smult(t))
+}
@@ -0,0 +1,85 @@
+package sobjs
+
+import (
+	"github.com/benvardy/raytracing/core"
+	"github.com/benvardy/raytracing/mats"
+)
+
+// bumpDelta is the step in U and V used to find the slope of a bump map
+const bumpDelta = 1e-3
+
+// ApplyNormalMap bends the shading normal at h by the normal map or bump map of material.
+// The normal stays on the same side of the surface as the geometric normal
+func (h *Hit) ApplyNormalMap(material mats.Material) {
+	var n vector3
+	switch {
+	case material.NormalMap != nil:
+		n = h.normalMapped(material.NormalMap)
+	case material.BumpMap != nil && material.BumpScale != 0:
+		n = h.bumped(material.BumpMap, material.BumpScale)
+	default:
+		return
+	}
+
+	if n.Length() == 0 {
+		return
+	}
+	n = n.Normalize()
+
+	// Keep the normal from tipping past the surface, which would make it face the wrong way
+	if n.Dot(h.Normal) <= 0 {
+		n = n.Subtract(h.Normal.Smult(n.Dot(h.Normal) - 1e-3)).Normalize()
+	}
+	h.ShadingNormal = n
+}
+
+// tangents returns unit vectors at right angles to the shading normal along U and against V,
+// so that they match the directions of red and green in a normal map
+func (h *Hit) tangents() (vector3, vector3) {
+	n := h.ShadingNormal
+
+	t := h.DPDU.Subtract(n.Smult(n.Dot(h.DPDU)))
+	if t.Length() < 1e-12 {
+		return core.OrthonormalBasis(n)
+	}
+	t = t.Normalize()
+
+	// Images have V going down, so up in the map is against DPDV
+	b := n.Cross(t)
+	if h.DPDV.Dot(b) > 0 {
+		b = b.Smult(-1)
+	}
+	return t, b
+}
+
+// normalMapped returns the shading normal given by the normal map tex
+func (h *Hit) normalMapped(tex mats.Texture) vector3 {
+	c := tex.Value(h.U, h.V, h.Point)
+	t, b := h.tangents()
+
+	return t.Smult(2*c.X - 1).Add(b.Smult(2*c.Y - 1)).Add(h.ShadingNormal.Smult(2*c.Z - 1))
+}
+
+// bumped returns the normal of the surface after moving each point along the normal by the
+// height in tex times scale
+func (h *Hit) bumped(tex mats.Texture, scale float64) vector3 {
+	n := h.ShadingNormal
+	dpdu, dpdv := h.DPDU, h.DPDV
+	if dpdu.Length() == 0 || dpdv.Length() == 0 {
+		dpdu, dpdv = core.OrthonormalBasis(n)
+	}
+
+	height := tex.Value(h.U, h.V, h.Point).X
+	du := tex.Value(h.U+bumpDelta, h.V, h.Point.Add(dpdu.Smult(bumpDelta))).X
+	dv := tex.Value(h.U, h.V+bumpDelta, h.Point.Add(dpdv.Smult(bumpDelta))).X
+
+	// The slope of the height changes how the point moves, ignoring the curve of the surface
+	dpdu = dpdu.Add(n.Smult(scale * (du - height) / bumpDelta))
+	dpdv = dpdv.Add(n.Smult(scale * (dv - height) / bumpDelta))
+
+	bent := dpdu.Cross(dpdv)
+	if bent.Dot(n) < 0 {
+		bent = bent.Smult(-1)
+	}
+	return bent
+}
@@ -0,0 +1,285 @@
+package sobjs
+
+import (
+	"sort"
+
+	"github.com/benvardy/raytracing/core"
+)
+
+// Tuning for the surface area heuristic
+const (
+	bvhBins          = 12
+	bvhMaxLeafSize   = 4
+	bvhTraversalCost = 1.0
+	bvhIntersectCost = 1.0
+)
+
+// BVH is a bounding volume hierarchy over a set of SceneObjects. Objects without bounds, like
+// Plane, are kept in a separate list and always tested
+type BVH struct {
+	nodes     []bvhNode
+	objects   []SceneObject
+	unbounded []SceneObject
+}
+
+// bvhNode is either a leaf holding objects[start:start+count] or an interior node with two children
+type bvhNode struct {
+	bounds      core.AABB
+	left, right int
+	start       int
+	count       int
+}
+
+// bvhItem is an object with its cached bounds used while building
+type bvhItem struct {
+	obj    SceneObject
+	bounds core.AABB
+	centre vector3
+}
+
+// NewBVH builds a BVH from objs using a binned surface area heuristic
+func NewBVH(objs []SceneObject) *BVH {
+	bvh := &BVH{}
+
+	items := make([]bvhItem, 0, len(objs))
+	for _, o := range objs {
+		if b, ok := o.GetBounds(); ok {
+			items = append(items, bvhItem{o, b, b.Centre()})
+		} else {
+			bvh.unbounded = append(bvh.unbounded, o)
+		}
+	}
+
+	if len(items) > 0 {
+		bvh.build(items, 0, len(items))
+	}
+
+	bvh.objects = make([]SceneObject, len(items))
+	for i, it := range items {
+		bvh.objects[i] = it.obj
+	}
+
+	return bvh
+}
+
+// build adds the node for items[start:end] and its children, returning the node's index.
+// items is reordered in place so every leaf refers to a contiguous range
+func (bvh *BVH) build(items []bvhItem, start, end int) int {
+	bounds := core.EmptyAABB()
+	centroids := core.EmptyAABB()
+	for _, it := range items[start:end] {
+		bounds = bounds.Union(it.bounds)
+		centroids = centroids.AddPoint(it.centre)
+	}
+
+	index := len(bvh.nodes)
+	bvh.nodes = append(bvh.nodes, bvhNode{bounds: bounds, start: start, count: end - start})
+
+	n := end - start
+	if n <= bvhMaxLeafSize {
+		return index
+	}
+
+	axis, split := bvh.findSplit(items[start:end], bounds, centroids)
+	if axis < 0 {
+		return index
+	}
+
+	// Partition the items about the split plane
+	mid := start
+	for i := start; i < end; i++ {
+		if axisOf(items[i].centre, axis) < split {
+			items[i], items[mid] = items[mid], items[i]
+			mid++
+		}
+	}
+
+	// Degenerate partition, fall back to a median split
+	if mid == start || mid == end {
+		sort.Slice(items[start:end], func(i, j int) bool {
+			return axisOf(items[start+i].centre, axis) < axisOf(items[start+j].centre, axis)
+		})
+		mid = start + n/2
+	}
+
+	left := bvh.build(items, start, mid)
+	right := bvh.build(items, mid, end)
+
+	bvh.nodes[index].left = left
+	bvh.nodes[index].right = right
+	bvh.nodes[index].count = 0
+
+	return index
+}
+
+// findSplit picks the axis and position to split items at. It returns an axis of -1 if keeping
+// the items in a single leaf is cheaper
+func (bvh *BVH) findSplit(items []bvhItem, bounds, centroids core.AABB) (int, float64) {
+	type bin struct {
+		bounds core.AABB
+		count  int
+	}
+
+	bestAxis, bestSplit := -1, 0.0
+	bestCost := bvhIntersectCost * float64(len(items))
+	area := bounds.SurfaceArea()
+
+	for axis := 0; axis < 3; axis++ {
+		lo, hi := axisOf(centroids.Min, axis), axisOf(centroids.Max, axis)
+		if hi-lo <= 0 {
+			continue
+		}
+
+		var bins [bvhBins]bin
+		for i := range bins {
+			bins[i].bounds = core.EmptyAABB()
+		}
+
+		scale := bvhBins / (hi - lo)
+		for _, it := range items {
+			b := int((axisOf(it.centre, axis) - lo) * scale)
+			if b >= bvhBins {
+				b = bvhBins - 1
+			}
+			bins[b].count++
+			bins[b].bounds = bins[b].bounds.Union(it.bounds)
+		}
+
+		// Sweep from the right to get the area and count of everything past each split
+		var rightArea [bvhBins]float64
+		var rightCount [bvhBins]int
+		acc, count := core.EmptyAABB(), 0
+		for i := bvhBins - 1; i > 0; i-- {
+			acc = acc.Union(bins[i].bounds)
+			count += bins[i].count
+			rightArea[i] = acc.SurfaceArea()
+			rightCount[i] = count
+		}
+
+		acc, count = core.EmptyAABB(), 0
+		for i := 0; i < bvhBins-1; i++ {
+			acc = acc.Union(bins[i].bounds)
+			count += bins[i].count
+			if count == 0 || rightCount[i+1] == 0 {
+				continue
+			}
+
+			cost := bvhTraversalCost + bvhIntersectCost*(acc.SurfaceArea()*float64(count)+rightArea[i+1]*float64(rightCount[i+1]))/area
+			if cost < bestCost {
+				bestAxis = axis
+				bestSplit = lo + float64(i+1)/scale
+				bestCost = cost
+			}
+		}
+	}
+
+	return bestAxis, bestSplit
+}
+
+// axisOf returns the component of v on axis 0, 1 or 2
+func axisOf(v vector3, axis int) float64 {
+	switch axis {
+	case 0:
+		return v.X
+	case 1:
+		return v.Y
+	}
+	return v.Z
+}
+
+// inverse returns the component wise inverse of d used for the slab tests
+func inverse(d vector3) vector3 {
+	return vector3{1 / d.X, 1 / d.Y, 1 / d.Z}
+}
+
+// ClosestHit returns the nearest hit of the ray s + λd with the objects in the BVH for λ in the
+// range (tMin, tMax), or nil if nothing is hit
+func (bvh *BVH) ClosestHit(s, d vector3, tMin, tMax float64) *Hit {
+	var closest *Hit
+
+	test := func(o SceneObject) {
+		if h := o.Intersect(s, d, tMin, tMax); h != nil {
+			closest, tMax = h, h.T
+		}
+	}
+
+	for _, o := range bvh.unbounded {
+		test(o)
+	}
+
+	if len(bvh.nodes) == 0 {
+		return closest
+	}
+
+	invD := inverse(d)
+	stack := make([]int, 0, 64)
+	stack = append(stack, 0)
+
+	for len(stack) > 0 {
+		node := &bvh.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+
+		if _, _, ok := node.bounds.IntersectWithRay(s, invD, tMin, tMax); !ok {
+			continue
+		}
+
+		if node.count > 0 {
+			for _, o := range bvh.objects[node.start : node.start+node.count] {
+				test(o)
+			}
+			continue
+		}
+
+		stack = append(stack, node.left, node.right)
+	}
+
+	return closest
+}
+
+// AnyHit reports whether any object intersects the ray s + λd for λ in the range (tMin, tMax).
+// It is used for shadow rays, so stops at the first hit found
+func (bvh *BVH) AnyHit(s, d vector3, tMin, tMax float64) bool {
+	for _, o := range bvh.unbounded {
+		if o.Intersect(s, d, tMin, tMax) != nil {
+			return true
+		}
+	}
+
+	if len(bvh.nodes) == 0 {
+		return false
+	}
+
+	invD := inverse(d)
+	stack := make([]int, 0, 64)
+	stack = append(stack, 0)
+
+	for len(stack) > 0 {
+		node := &bvh.nodes[stack[len(stack)-1]]
+		stack = stack[:len(stack)-1]
+
+		if _, _, ok := node.bounds.IntersectWithRay(s, invD, tMin, tMax); !ok {
+			continue
+		}
+
+		if node.count > 0 {
+			for _, o := range bvh.objects[node.start : node.start+node.count] {
+				if o.Intersect(s, d, tMin, tMax) != nil {
+					return true
+				}
+			}
+			continue
+		}
+
+		stack = append(stack, node.left, node.right)
+	}
+
+	return false
+}
+
+// Bounds returns the box around every bounded object in the BVH, or false if there are none
+func (bvh *BVH) Bounds() (core.AABB, bool) {
+	if len(bvh.nodes) == 0 {
+		return core.AABB{}, false
+	}
+	return bvh.nodes[0].bounds, true
+}
@@ -0,0 +1,48 @@
+package sobjs
+
+import (
+	"github.com/benvardy/raytracing/core"
+	"github.com/benvardy/raytracing/mats"
+)
+
+// Cone is a finite cone SceneObject with its base centred on Base and its point Height along
+// Axis. The base is closed by a disk if Capped is set
+type Cone struct {
+	Base vector3
+	Mat  mats.Material
+
+	Axis   vector3
+	Height float64
+	// Radius of the base
+	Radius float64
+	Capped bool
+
+	shape frustum
+}
+
+// NewCone creates a cone with its base at base pointing along axis
+func NewCone(base, axis vector3, height, radius float64, capped bool, material mats.Material) *Cone {
+	axis = axis.Normalize()
+	return &Cone{base, material, axis, height, radius, capped, newFrustum(base, axis, height, radius, 0, capped)}
+}
+
+// Intersect implements the SceneObject function
+func (cone *Cone) Intersect(s, d vector3, tMin, tMax float64) *Hit {
+	return cone.shape.intersect(cone, s, d, tMin, tMax)
+}
+
+// GetNormal gets the normal at the hit h, flipped to face the light l like Disk so the inside of
+// an open cone is lit
+func (cone *Cone) GetNormal(h *Hit, l vector3) vector3 {
+	return h.FacingNormal(l)
+}
+
+// GetMaterial gets the mats.Material
+func (cone *Cone) GetMaterial() mats.Material {
+	return cone.Mat
+}
+
+// GetBounds gets the box around the cone
+func (cone *Cone) GetBounds() (core.AABB, bool) {
+	return cone.shape.bounds(), true
+}
@@ -0,0 +1,49 @@
+package sobjs
+
+import (
+	"github.com/benvardy/raytracing/core"
+	"github.com/benvardy/raytracing/mats"
+)
+
+// Cylinder is a finite cylinder SceneObject centred on Centre, with its ends closed by disks
+// if Capped is set
+type Cylinder struct {
+	Centre vector3
+	Mat    mats.Material
+
+	Axis vector3
+	// Total height of the cylinder
+	Height float64
+	Radius float64
+	Capped bool
+
+	shape frustum
+}
+
+// NewCylinder creates a cylinder centred on centre along axis
+func NewCylinder(centre, axis vector3, height, radius float64, capped bool, material mats.Material) *Cylinder {
+	axis = axis.Normalize()
+	base := centre.Subtract(axis.Smult(height / 2))
+	return &Cylinder{centre, material, axis, height, radius, capped, newFrustum(base, axis, height, radius, radius, capped)}
+}
+
+// Intersect implements the SceneObject function
+func (cylinder *Cylinder) Intersect(s, d vector3, tMin, tMax float64) *Hit {
+	return cylinder.shape.intersect(cylinder, s, d, tMin, tMax)
+}
+
+// GetNormal gets the normal at the hit h, flipped to face the light l like Disk so the inside of
+// an open cylinder is lit
+func (cylinder *Cylinder) GetNormal(h *Hit, l vector3) vector3 {
+	return h.FacingNormal(l)
+}
+
+// GetMaterial gets the mats.Material
+func (cylinder *Cylinder) GetMaterial() mats.Material {
+	return cylinder.Mat
+}
+
+// GetBounds gets the box around the cylinder
+func (cylinder *Cylinder) GetBounds() (core.AABB, bool) {
+	return cylinder.shape.bounds(), true
+}
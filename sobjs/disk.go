@@ -1,6 +1,11 @@
 package sobjs
 
-import "github.com/benvardy/raytracing/mats"
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/core"
+	"github.com/benvardy/raytracing/mats"
+)
 
 // Disk is a disk - a plane with bounds SceneObject
 type Disk struct {
@@ -13,28 +18,42 @@ func NewDisk(pos vector3, normal vector3, radius float64, material mats.Material
 	return &Disk{NewPlane(pos, normal, material), radius}
 }
 
-// IntersectWithRay implements the SceneObject function
-func (disk *Disk) IntersectWithRay(s, d vector3) *vector3 {
+// Intersect implements the SceneObject function
+func (disk *Disk) Intersect(s, d vector3, tMin, tMax float64) *Hit {
 	rp := disk.RootPlane
 
-	vPtr := rp.IntersectWithRay(s, d)
-	if vPtr != nil && rp.Position.Subtract(*vPtr).Length() <= disk.Radius {
-		return vPtr
+	h := rp.Intersect(s, d, tMin, tMax)
+	if h == nil || rp.Position.Subtract(h.Point).Length() > disk.Radius {
+		return nil
 	}
 
-	return nil
+	// Scale the plane coordinates so the disk covers [0, 1]
+	h.U = 0.5 + h.U/(2*disk.Radius)
+	h.V = 0.5 + h.V/(2*disk.Radius)
+	h.DPDU = h.DPDU.Smult(2 * disk.Radius)
+	h.DPDV = h.DPDV.Smult(2 * disk.Radius)
+	h.Object = disk
+	return h
 }
 
-// GetNormal gets the normal at the point p
-func (disk *Disk) GetNormal(p, l vector3) vector3 {
-	n := disk.RootPlane.GetNormal(p, l)
-	if n.Dot(l.Subtract(p)) < 0 {
-		return n.Smult(-1)
-	}
-	return n
+// GetNormal gets the normal at the hit h, flipped to face the light l
+func (disk *Disk) GetNormal(h *Hit, l vector3) vector3 {
+	return h.FacingNormal(l)
 }
 
 // GetMaterial gets the mats.Material
 func (disk *Disk) GetMaterial() mats.Material {
 	return disk.RootPlane.GetMaterial()
 }
+
+// GetBounds gets the box around the disk. On each axis the disk extends r * sqrt(1 - n_i^2)
+func (disk *Disk) GetBounds() (core.AABB, bool) {
+	n, r := disk.RootPlane.Normal, disk.Radius
+	e := vector3{
+		r * math.Sqrt(math.Max(0, 1-n.X*n.X)),
+		r * math.Sqrt(math.Max(0, 1-n.Y*n.Y)),
+		r * math.Sqrt(math.Max(0, 1-n.Z*n.Z)),
+	}
+	pos := disk.RootPlane.Position
+	return core.AABB{Min: pos.Subtract(e), Max: pos.Add(e)}, true
+}
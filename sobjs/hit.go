@@ -0,0 +1,43 @@
+package sobjs
+
+// Hit records where a ray s + λd hit a SceneObject
+type Hit struct {
+	// T is the value of λ at the hit
+	T     float64
+	Point vector3
+	// Normal is the geometric normal and ShadingNormal the one used for lighting, which differs
+	// for smooth shaded triangles. Both point out of the surface whichever side was hit
+	Normal        vector3
+	ShadingNormal vector3
+	// U and V are the surface coordinates of the hit
+	U, V float64
+	// DPDU and DPDV are how Point moves as U and V change, which orients normal and bump maps.
+	// They are zero for objects without a parameterisation
+	DPDU, DPDV vector3
+	// FrontFace is true if the ray hit the side the normal points out of
+	FrontFace bool
+	Object    SceneObject
+}
+
+// newHit fills in a Hit from the ray and the outward normal n at λ = t
+func newHit(obj SceneObject, s, d vector3, t float64, n vector3, u, v float64) *Hit {
+	return &Hit{
+		T:             t,
+		Point:         s.Add(d.Smult(t)),
+		Normal:        n,
+		ShadingNormal: n,
+		U:             u,
+		V:             v,
+		FrontFace:     d.Dot(n) < 0,
+		Object:        obj,
+	}
+}
+
+// FacingNormal returns the shading normal flipped if needed to be on the same side as the point l.
+// This lets objects without an inside, like Disk, be lit from either side
+func (h *Hit) FacingNormal(l vector3) vector3 {
+	if h.ShadingNormal.Dot(l.Subtract(h.Point)) < 0 {
+		return h.ShadingNormal.Smult(-1)
+	}
+	return h.ShadingNormal
+}
@@ -0,0 +1,141 @@
+package sobjs
+
+import (
+	"github.com/benvardy/raytracing/core"
+	"github.com/benvardy/raytracing/mats"
+)
+
+// Mesh is an indexed triangle mesh SceneObject. The triangles share the vertex buffers and are
+// held in their own BVH, so a mesh can be added to a scene like any other object
+type Mesh struct {
+	Vertices []vector3
+	// Normals holds a normal per vertex, or is empty for flat shading
+	Normals []vector3
+	// UVs holds texture coordinates per vertex as (u, v, 0), or is empty
+	UVs []vector3
+	// Indices holds three indices into the vertex buffers per triangle
+	Indices []int
+	Mat     mats.Material
+
+	bvh *BVH
+	// areas is the running total of the triangles' areas, for sampling points on the mesh
+	areas []float64
+}
+
+// meshTriangle is one face of a Mesh, it is what the mesh's BVH is built from
+type meshTriangle struct {
+	mesh  *Mesh
+	index int
+}
+
+// NewMesh creates a mesh from vertex buffers, normals and uvs may be nil
+func NewMesh(vertices, normals, uvs []vector3, indices []int, material mats.Material) *Mesh {
+	mesh := &Mesh{vertices, normals, uvs, indices, material, nil, nil}
+	mesh.build()
+	return mesh
+}
+
+// build makes the BVH over the triangles of the mesh and the table for sampling them
+func (mesh *Mesh) build() {
+	mesh.buildAreas()
+
+	tris := make([]SceneObject, len(mesh.Indices)/3)
+	for i := range tris {
+		tris[i] = &meshTriangle{mesh, i}
+	}
+
+	mesh.bvh = NewBVH(tris)
+}
+
+// Transform scales the mesh about the origin and then moves it by offset
+func (mesh *Mesh) Transform(scale float64, offset vector3) {
+	for i, v := range mesh.Vertices {
+		mesh.Vertices[i] = v.Smult(scale).Add(offset)
+	}
+
+	// A negative scale mirrors the mesh so the normals have to follow. The face normals come
+	// from the winding, which is reversed by swapping two corners of each triangle
+	if scale < 0 {
+		for i, n := range mesh.Normals {
+			mesh.Normals[i] = n.Smult(-1)
+		}
+		for i := 0; i+2 < len(mesh.Indices); i += 3 {
+			mesh.Indices[i+1], mesh.Indices[i+2] = mesh.Indices[i+2], mesh.Indices[i+1]
+		}
+	}
+
+	mesh.build()
+}
+
+// TriangleCount returns the number of triangles in the mesh
+func (mesh *Mesh) TriangleCount() int {
+	return len(mesh.Indices) / 3
+}
+
+// Intersect implements the SceneObject function
+func (mesh *Mesh) Intersect(s, d vector3, tMin, tMax float64) *Hit {
+	h := mesh.bvh.ClosestHit(s, d, tMin, tMax)
+	if h != nil {
+		h.Object = mesh
+	}
+	return h
+}
+
+// GetNormal gets the normal at the hit h, flipped to face the light l
+func (mesh *Mesh) GetNormal(h *Hit, l vector3) vector3 {
+	return h.FacingNormal(l)
+}
+
+// GetMaterial gets the mats.Material
+func (mesh *Mesh) GetMaterial() mats.Material {
+	return mesh.Mat
+}
+
+// GetBounds gets the box around every vertex
+func (mesh *Mesh) GetBounds() (core.AABB, bool) {
+	return mesh.bvh.Bounds()
+}
+
+// vertices returns the corners of the triangle
+func (t *meshTriangle) vertices() (vector3, vector3, vector3) {
+	i := t.mesh.Indices[3*t.index:]
+	return t.mesh.Vertices[i[0]], t.mesh.Vertices[i[1]], t.mesh.Vertices[i[2]]
+}
+
+func (t *meshTriangle) Intersect(s, d vector3, tMin, tMax float64) *Hit {
+	v0, v1, v2 := t.vertices()
+	lambda, u, v, ok := intersectTriangle(v0, v1, v2, s, d, tMin, tMax)
+	if !ok {
+		return nil
+	}
+
+	h := newHit(t, s, d, lambda, faceNormal(v0, v1, v2), u, v)
+
+	i := t.mesh.Indices[3*t.index:]
+	if normals := t.mesh.Normals; len(normals) > 0 {
+		h.ShadingNormal = interpolate(normals[i[0]], normals[i[1]], normals[i[2]], u, v).Normalize()
+	}
+	h.DPDU, h.DPDV = v1.Subtract(v0), v2.Subtract(v0)
+	if uvs := t.mesh.UVs; len(uvs) > 0 {
+		uv := interpolate(uvs[i[0]], uvs[i[1]], uvs[i[2]], u, v)
+		h.U, h.V = uv.X, uv.Y
+		if dpdu, dpdv, ok := uvDerivatives(v0, v1, v2, uvs[i[0]], uvs[i[1]], uvs[i[2]]); ok {
+			h.DPDU, h.DPDV = dpdu, dpdv
+		}
+	}
+
+	return h
+}
+
+func (t *meshTriangle) GetNormal(h *Hit, l vector3) vector3 {
+	return h.FacingNormal(l)
+}
+
+func (t *meshTriangle) GetMaterial() mats.Material {
+	return t.mesh.Mat
+}
+
+func (t *meshTriangle) GetBounds() (core.AABB, bool) {
+	v0, v1, v2 := t.vertices()
+	return triangleBounds(v0, v1, v2), true
+}
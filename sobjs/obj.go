@@ -0,0 +1,335 @@
+package sobjs
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/benvardy/raytracing/mats"
+)
+
+// objCorner is the position, texture and normal index of one corner of an OBJ face
+type objCorner struct {
+	v, vt, vn int
+}
+
+// objGroup collects the faces using one material while reading an OBJ file
+type objGroup struct {
+	material string
+	vertices []vector3
+	normals  []vector3
+	uvs      []vector3
+	indices  []int
+	// lookup maps each distinct corner to its index in the vertex buffers
+	lookup map[objCorner]int
+	// hasNormals is false if any corner is missing a normal
+	hasNormals bool
+	hasUVs     bool
+}
+
+// LoadOBJ reads a Wavefront OBJ file and returns one Mesh for each material it uses. Materials
+// are read from the MTL files named by mtllib, faces without a known material use def
+func LoadOBJ(fname string, def mats.Material) ([]*Mesh, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readOBJ(f, fname, filepath.Dir(fname), def)
+}
+
+// readOBJ parses the OBJ data in r, loading MTL files relative to dir
+func readOBJ(r io.Reader, fname, dir string, def mats.Material) ([]*Mesh, error) {
+	var positions, normals, uvs []vector3
+
+	materials := make(map[string]mats.Material)
+	groups := make(map[string]*objGroup)
+	order := make([]string, 0)
+	current := ""
+
+	group := func() *objGroup {
+		g, ok := groups[current]
+		if !ok {
+			g = &objGroup{material: current, lookup: make(map[objCorner]int), hasNormals: true, hasUVs: true}
+			groups[current] = g
+			order = append(order, current)
+		}
+		return g
+	}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		fail := func(format string, args ...interface{}) error {
+			return fmt.Errorf("%s:%d: %s", fname, line, fmt.Sprintf(format, args...))
+		}
+
+		switch fields[0] {
+		case "v", "vn":
+			v, err := parseVector(fields[1:], 3)
+			if err != nil {
+				return nil, fail("%s: %v", fields[0], err)
+			}
+			if fields[0] == "v" {
+				positions = append(positions, v)
+			} else {
+				normals = append(normals, v.Normalize())
+			}
+		case "vt":
+			v, err := parseVector(fields[1:], 1)
+			if err != nil {
+				return nil, fail("vt: %v", err)
+			}
+			// OBJ puts v = 0 at the bottom of an image, but textures have it at the top
+			v.Y = 1 - v.Y
+			uvs = append(uvs, v)
+		case "f":
+			if len(fields) < 4 {
+				return nil, fail("f: a face needs at least 3 vertices")
+			}
+
+			g := group()
+			corners := make([]int, 0, len(fields)-1)
+			for _, field := range fields[1:] {
+				c, err := parseCorner(field, len(positions), len(uvs), len(normals))
+				if err != nil {
+					return nil, fail("f: %v", err)
+				}
+
+				index, ok := g.lookup[c]
+				if !ok {
+					index = len(g.vertices)
+					g.lookup[c] = index
+					g.vertices = append(g.vertices, positions[c.v])
+
+					if c.vn >= 0 {
+						g.normals = append(g.normals, normals[c.vn])
+					} else {
+						g.normals = append(g.normals, vector3{})
+						g.hasNormals = false
+					}
+
+					if c.vt >= 0 {
+						g.uvs = append(g.uvs, uvs[c.vt])
+					} else {
+						g.uvs = append(g.uvs, vector3{})
+						g.hasUVs = false
+					}
+				}
+				corners = append(corners, index)
+			}
+
+			// Triangulate polygons as a fan around the first vertex
+			for i := 1; i+1 < len(corners); i++ {
+				g.indices = append(g.indices, corners[0], corners[i], corners[i+1])
+			}
+		case "usemtl":
+			current = strings.Join(fields[1:], " ")
+		case "mtllib":
+			for _, lib := range fields[1:] {
+				if err := loadMTL(filepath.Join(dir, lib), materials); err != nil {
+					return nil, fail("mtllib: %v", err)
+				}
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", fname, err)
+	}
+
+	meshes := make([]*Mesh, 0, len(order))
+	for _, name := range order {
+		g := groups[name]
+		if len(g.indices) == 0 {
+			continue
+		}
+
+		mat, ok := materials[name]
+		if !ok {
+			mat = def
+		}
+
+		var meshNormals, meshUVs []vector3
+		if g.hasNormals {
+			meshNormals = g.normals
+		}
+		if g.hasUVs {
+			meshUVs = g.uvs
+		}
+
+		meshes = append(meshes, NewMesh(g.vertices, meshNormals, meshUVs, g.indices, mat))
+	}
+
+	return meshes, nil
+}
+
+// parseVector reads up to three floats into a vector, at least min must be present
+func parseVector(fields []string, min int) (vector3, error) {
+	if len(fields) < min {
+		return vector3{}, fmt.Errorf("expected at least %d values, got %d", min, len(fields))
+	}
+
+	var v [3]float64
+	for i := 0; i < len(fields) && i < 3; i++ {
+		f, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return vector3{}, err
+		}
+		v[i] = f
+	}
+
+	return vector3{v[0], v[1], v[2]}, nil
+}
+
+// parseColor reads an MTL colour, a single value is used for all three channels
+func parseColor(fields []string) (vector3, error) {
+	v, err := parseVector(fields, 1)
+	if err == nil && len(fields) == 1 {
+		v.Y, v.Z = v.X, v.X
+	}
+
+	return v, err
+}
+
+// parseCorner reads a face vertex of the form v, v/vt, v//vn or v/vt/vn. Indices start at 1
+// and negative indices count back from the latest vertex. Missing indices are returned as -1
+func parseCorner(field string, nv, nvt, nvn int) (objCorner, error) {
+	parts := strings.Split(field, "/")
+	if len(parts) > 3 {
+		return objCorner{}, fmt.Errorf("bad vertex %q", field)
+	}
+
+	c := objCorner{-1, -1, -1}
+	counts := []int{nv, nvt, nvn}
+	out := []*int{&c.v, &c.vt, &c.vn}
+
+	for i, part := range parts {
+		if part == "" {
+			if i == 0 {
+				return objCorner{}, fmt.Errorf("bad vertex %q", field)
+			}
+			continue
+		}
+
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return objCorner{}, fmt.Errorf("bad vertex %q: %v", field, err)
+		}
+
+		if n < 0 {
+			n += counts[i]
+		} else {
+			n--
+		}
+
+		if n < 0 || n >= counts[i] {
+			return objCorner{}, fmt.Errorf("index out of range in %q", field)
+		}
+		*out[i] = n
+	}
+
+	return c, nil
+}
+
+// loadMTL reads the materials in an MTL file into materials. Ka, Kd and Ks map directly, the
+// specular exponent Ns becomes the Roughness and illumination models with reflection use Ks as
+// the reflectivity. The texture maps map_Kd and map_Ks are loaded relative to the MTL file
+func loadMTL(fname string, materials map[string]mats.Material) error {
+	f, err := os.Open(fname)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var name string
+	var mat *mats.Material
+	var reflective bool
+	save := func() {
+		if mat != nil {
+			if reflective {
+				mat.Reflectivity = (mat.Ks.X + mat.Ks.Y + mat.Ks.Z) / 3
+			}
+			materials[name] = *mat
+		}
+	}
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		if fields[0] == "newmtl" {
+			save()
+			name = strings.Join(fields[1:], " ")
+			mat = &mats.Material{Roughness: 1}
+			reflective = false
+			continue
+		}
+
+		if mat == nil {
+			continue
+		}
+
+		var err error
+		switch fields[0] {
+		case "Ka":
+			mat.Ka, err = parseColor(fields[1:])
+		case "Kd":
+			mat.Kd, err = parseColor(fields[1:])
+		case "Ks":
+			mat.Ks, err = parseColor(fields[1:])
+		case "Ns":
+			if len(fields) < 2 {
+				err = fmt.Errorf("missing value")
+			} else {
+				mat.Roughness, err = strconv.ParseFloat(fields[1], 64)
+			}
+		case "illum":
+			// Models 3 to 7 turn on ray traced reflection
+			var model int
+			if len(fields) < 2 {
+				err = fmt.Errorf("missing value")
+			} else if model, err = strconv.Atoi(fields[1]); err == nil {
+				reflective = model >= 3 && model <= 7
+			}
+		case "map_Kd", "map_Ks":
+			// Options come before the file name, which is all that is used
+			if len(fields) < 2 {
+				err = fmt.Errorf("missing file")
+				break
+			}
+
+			var tex *mats.ImageTexture
+			tex, err = mats.LoadImageTexture(filepath.Join(filepath.Dir(fname), fields[len(fields)-1]), mats.WrapRepeat, true)
+			// The map is multiplied by the colour as the MTL format specifies
+			if fields[0] == "map_Kd" {
+				mat.KdMap = tex
+			} else {
+				mat.KsMap = tex
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %v", fname, line, fields[0], err)
+		}
+	}
+	save()
+
+	return scanner.Err()
+}
@@ -1,6 +1,9 @@
 package sobjs
 
-import "github.com/benvardy/raytracing/mats"
+import (
+	"github.com/benvardy/raytracing/core"
+	"github.com/benvardy/raytracing/mats"
+)
 
 // Plane is a plane SceneObject
 type Plane struct {
@@ -14,9 +17,9 @@ func NewPlane(pos, normal vector3, material mats.Material) *Plane {
 	return &Plane{pos, material, normal.Normalize()}
 }
 
-// IntersectWithRay implements the SceneObject function
+// Intersect implements the SceneObject function
 //  Solves the equation `(s + λd - q) . n = 0`
-func (plane *Plane) IntersectWithRay(s, d vector3) *vector3 {
+func (plane *Plane) Intersect(s, d vector3, tMin, tMax float64) *Hit {
 	n := plane.Normal
 	pos := plane.Position
 
@@ -26,22 +29,34 @@ func (plane *Plane) IntersectWithRay(s, d vector3) *vector3 {
 
 	lambda := n.Dot(pos.Subtract(s)) / n.Dot(d)
 
-	if lambda <= 0 {
+	if lambda <= tMin || lambda >= tMax {
 		return nil
 	}
 
-	res := s.Add(d.Smult(lambda))
-	return &res
+	h := newHit(plane, s, d, lambda, n, 0, 0)
+	h.U, h.V = plane.uv(h.Point)
+	h.DPDU, h.DPDV = core.OrthonormalBasis(plane.Normal)
+	return h
 }
 
-// GetNormal gets the normal at the point p
-func (plane *Plane) GetNormal(p, _ vector3) vector3 {
-	n := plane.Normal.Normalize()
+// uv returns the coordinates of p along two axes in the plane, measured from Position
+func (plane *Plane) uv(p vector3) (float64, float64) {
+	t, b := core.OrthonormalBasis(plane.Normal)
+	offset := p.Subtract(plane.Position)
+	return offset.Dot(t), offset.Dot(b)
+}
 
-	return n
+// GetNormal gets the normal at the hit h
+func (plane *Plane) GetNormal(h *Hit, _ vector3) vector3 {
+	return h.ShadingNormal
 }
 
 // GetMaterial gets the mats.Material
 func (plane *Plane) GetMaterial() mats.Material {
 	return plane.Mat
 }
+
+// GetBounds returns false as a plane is infinite
+func (plane *Plane) GetBounds() (core.AABB, bool) {
+	return core.AABB{}, false
+}
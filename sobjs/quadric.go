@@ -0,0 +1,125 @@
+package sobjs
+
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/core"
+)
+
+// frustum is the geometry shared by Cylinder and Cone: a cone cut off by two planes whose
+// radius changes linearly from r0 at the base to r1 at height h along the axis.
+// In the frustum's frame the side is the quadric `x^2 + y^2 = (r0 + kz)^2` for 0 <= z <= h,
+// where k = (r1 - r0) / h
+type frustum struct {
+	base                     vector3
+	axis, tangent, bitangent vector3
+	height, r0, r1           float64
+	capped                   bool
+}
+
+func newFrustum(base, axis vector3, height, r0, r1 float64, capped bool) frustum {
+	axis = axis.Normalize()
+	t, b := core.OrthonormalBasis(axis)
+	return frustum{base, axis, t, b, height, r0, r1, capped}
+}
+
+// toLocal converts a world vector to the frustum's frame, where the axis is z
+func (f *frustum) toLocal(v vector3) vector3 {
+	return vector3{v.Dot(f.tangent), v.Dot(f.bitangent), v.Dot(f.axis)}
+}
+
+// toWorld converts a vector in the frustum's frame back to world space
+func (f *frustum) toWorld(v vector3) vector3 {
+	return f.tangent.Smult(v.X).Add(f.bitangent.Smult(v.Y)).Add(f.axis.Smult(v.Z))
+}
+
+// intersect finds the closest hit with the side or caps of the frustum for λ in (tMin, tMax),
+// with obj recorded as the object that was hit
+func (f *frustum) intersect(obj SceneObject, s, d vector3, tMin, tMax float64) *Hit {
+	ls := f.toLocal(s.Subtract(f.base))
+	ld := f.toLocal(d)
+
+	k := (f.r1 - f.r0) / f.height
+	rs := f.r0 + k*ls.Z
+
+	a := ld.X*ld.X + ld.Y*ld.Y - k*k*ld.Z*ld.Z
+	b := 2 * (ls.X*ld.X + ls.Y*ld.Y - k*ld.Z*rs)
+	c := ls.X*ls.X + ls.Y*ls.Y - rs*rs
+
+	var best *Hit
+	try := func(lambda float64, n vector3, u, v float64, dpdu, dpdv vector3) {
+		if lambda > tMin && lambda < tMax && (best == nil || lambda < best.T) {
+			best = newHit(obj, s, d, lambda, f.toWorld(n).Normalize(), u, v)
+			best.DPDU, best.DPDV = f.toWorld(dpdu), f.toWorld(dpdv)
+		}
+	}
+
+	side := func(lambda float64) {
+		p := ls.Add(ld.Smult(lambda))
+		if p.Z < 0 || p.Z > f.height {
+			return
+		}
+
+		// The gradient of the quadric, with the radius at this height
+		n := vector3{p.X, p.Y, -k * (f.r0 + k*p.Z)}
+		u := (math.Atan2(p.Y, p.X) + math.Pi) / (2 * math.Pi)
+
+		// Moving up the side the radius changes by k
+		dpdv := vector3{0, 0, f.height}
+		if rho := math.Sqrt(p.X*p.X + p.Y*p.Y); rho > 0 {
+			dpdv = vector3{k * p.X / rho, k * p.Y / rho, 1}.Smult(f.height)
+		}
+		try(lambda, n, u, p.Z/f.height, vector3{-p.Y, p.X, 0}.Smult(2*math.Pi), dpdv)
+	}
+
+	if a != 0 {
+		disc := b*b - 4*a*c
+		if disc >= 0 {
+			sq := math.Sqrt(disc)
+			side((-b - sq) / (2 * a))
+			side((-b + sq) / (2 * a))
+		}
+	} else if b != 0 {
+		// The ray is parallel to the side of a cone
+		side(-c / b)
+	}
+
+	if f.capped && ld.Z != 0 {
+		caps := []struct {
+			z, r float64
+			n    vector3
+		}{
+			{0, f.r0, vector3{0, 0, -1}},
+			{f.height, f.r1, vector3{0, 0, 1}},
+		}
+
+		for _, cp := range caps {
+			if cp.r <= 0 {
+				continue
+			}
+
+			lambda := (cp.z - ls.Z) / ld.Z
+			p := ls.Add(ld.Smult(lambda))
+			if p.X*p.X+p.Y*p.Y <= cp.r*cp.r {
+				try(lambda, cp.n, 0.5+p.X/(2*cp.r), 0.5+p.Y/(2*cp.r), vector3{2 * cp.r, 0, 0}, vector3{0, 2 * cp.r, 0})
+			}
+		}
+	}
+
+	return best
+}
+
+// bounds returns the box around the two ends of the frustum
+func (f *frustum) bounds() core.AABB {
+	a := f.axis
+	disk := func(centre vector3, r float64) core.AABB {
+		e := vector3{
+			r * math.Sqrt(math.Max(0, 1-a.X*a.X)),
+			r * math.Sqrt(math.Max(0, 1-a.Y*a.Y)),
+			r * math.Sqrt(math.Max(0, 1-a.Z*a.Z)),
+		}
+		return core.AABB{Min: centre.Subtract(e), Max: centre.Add(e)}
+	}
+
+	return disk(f.base, f.r0).Union(disk(f.base.Add(a.Smult(f.height)), f.r1))
+}
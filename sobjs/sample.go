@@ -0,0 +1,111 @@
+package sobjs
+
+import (
+	"math"
+	"sort"
+
+	"github.com/benvardy/raytracing/core"
+)
+
+// Sampler is implemented by objects that can pick points spread evenly over their surface,
+// which lets glowing objects be used as lights
+type Sampler interface {
+	// Area returns the surface area of the object
+	Area() float64
+	// SamplePoint picks a point on the surface using two uniform random numbers in [0, 1) and
+	// returns it with the outward geometric normal there
+	SamplePoint(u1, u2 float64) (vector3, vector3)
+}
+
+// Area implements the Sampler function
+func (sphere *Sphere) Area() float64 {
+	return 4 * math.Pi * sphere.Radius * sphere.Radius
+}
+
+// SamplePoint implements the Sampler function
+func (sphere *Sphere) SamplePoint(u1, u2 float64) (vector3, vector3) {
+	z := 1 - 2*u1
+	r := math.Sqrt(math.Max(0, 1-z*z))
+	phi := 2 * math.Pi * u2
+
+	n := vector3{r * math.Cos(phi), r * math.Sin(phi), z}
+	return sphere.Position.Add(n.Smult(sphere.Radius)), n
+}
+
+// Area implements the Sampler function
+func (disk *Disk) Area() float64 {
+	return math.Pi * disk.Radius * disk.Radius
+}
+
+// SamplePoint implements the Sampler function
+func (disk *Disk) SamplePoint(u1, u2 float64) (vector3, vector3) {
+	rp := disk.RootPlane
+	t, b := core.OrthonormalBasis(rp.Normal)
+
+	r := disk.Radius * math.Sqrt(u1)
+	phi := 2 * math.Pi * u2
+	return rp.Position.Add(t.Smult(r * math.Cos(phi))).Add(b.Smult(r * math.Sin(phi))), rp.Normal
+}
+
+// sampleTriangle picks a point evenly over the triangle
+func sampleTriangle(v0, v1, v2 vector3, u1, u2 float64) (vector3, vector3) {
+	su := math.Sqrt(u1)
+	b0, b1 := 1-su, u2*su
+	p := v0.Smult(b0).Add(v1.Smult(b1)).Add(v2.Smult(1 - b0 - b1))
+	return p, faceNormal(v0, v1, v2)
+}
+
+// triangleArea returns the area of the triangle
+func triangleArea(v0, v1, v2 vector3) float64 {
+	return v1.Subtract(v0).Cross(v2.Subtract(v0)).Length() / 2
+}
+
+// Area implements the Sampler function
+func (tri *Triangle) Area() float64 {
+	return triangleArea(tri.V0, tri.V1, tri.V2)
+}
+
+// SamplePoint implements the Sampler function
+func (tri *Triangle) SamplePoint(u1, u2 float64) (vector3, vector3) {
+	return sampleTriangle(tri.V0, tri.V1, tri.V2, u1, u2)
+}
+
+// Area implements the Sampler function
+func (mesh *Mesh) Area() float64 {
+	if len(mesh.areas) == 0 {
+		return 0
+	}
+	return mesh.areas[len(mesh.areas)-1]
+}
+
+// SamplePoint implements the Sampler function, picking a triangle in proportion to its area
+// with u1 and then reusing what is left of u1 to place the point in it
+func (mesh *Mesh) SamplePoint(u1, u2 float64) (vector3, vector3) {
+	total := mesh.Area()
+	target := u1 * total
+	i := sort.SearchFloat64s(mesh.areas, target)
+	if i == len(mesh.areas) {
+		i--
+	}
+
+	start := 0.0
+	if i > 0 {
+		start = mesh.areas[i-1]
+	}
+	if size := mesh.areas[i] - start; size > 0 {
+		u1 = math.Min(1, (target-start)/size)
+	}
+
+	v0, v1, v2 := (&meshTriangle{mesh, i}).vertices()
+	return sampleTriangle(v0, v1, v2, u1, u2)
+}
+
+// buildAreas fills the running total of the triangles' areas used by SamplePoint
+func (mesh *Mesh) buildAreas() {
+	mesh.areas = make([]float64, mesh.TriangleCount())
+	total := 0.0
+	for i := range mesh.areas {
+		total += triangleArea((&meshTriangle{mesh, i}).vertices())
+		mesh.areas[i] = total
+	}
+}
@@ -3,6 +3,7 @@ package sobjs
 import (
 	"math"
 
+	"github.com/benvardy/raytracing/core"
 	"github.com/benvardy/raytracing/mats"
 )
 
@@ -18,10 +19,10 @@ func NewSphere(pos vector3, r float64, material mats.Material) *Sphere {
 	return &Sphere{pos, material, r}
 }
 
-// IntersectWithRay implements the SceneObject function
+// Intersect implements the SceneObject function
 //  Solves the equation `aλ^2 + bλ + c = 0`, where:
 //  `a = d.d, b = 2*(d.(s - c)), c = (s - c).(s - c) - r^2`
-func (sphere *Sphere) IntersectWithRay(s, d vector3) *vector3 {
+func (sphere *Sphere) Intersect(s, d vector3, tMin, tMax float64) *Hit {
 	center := sphere.Position
 	r := sphere.Radius
 
@@ -44,35 +45,57 @@ func (sphere *Sphere) IntersectWithRay(s, d vector3) *vector3 {
 		return nil
 	}
 
-	// lambda1 := (-b + math.Sqrt(b*b-4*a*c)) / (2 * a)
-	// lambda2 := (-b - math.Sqrt(b*b-4*a*c)) / (2 * a)
-
-	// p1 := s.Add(d.Smult(lambda1))
-	// p2 := s.Add(d.Smult(lambda2))
+	// Try the nearer root first, the further one is hit from inside the sphere
+	lambda := (-b - math.Sqrt(discriminant)) / (2 * a)
+	if lambda <= tMin || lambda >= tMax {
+		lambda = (-b + math.Sqrt(discriminant)) / (2 * a)
+		if lambda <= tMin || lambda >= tMax {
+			return nil
+		}
+	}
 
-	// if p1.Length() < p2.Length() {
-	// 	return &p1
-	// }
+	n := offset.Add(d.Smult(lambda)).Smult(1 / r)
+	u, v := sphereUV(n)
 
-	// return &p2
+	h := newHit(sphere, s, d, lambda, n, u, v)
+	h.DPDU, h.DPDV = sphereDerivatives(n, r)
+	return h
+}
 
-	// Only have to calculate the smaller value of lambda as
-	lambda := (-b - math.Sqrt(discriminant)) / (2 * a)
-	if lambda < 0 {
-		return nil
+// sphereDerivatives returns how the point at the unit vector n on a sphere of radius r moves
+// with the coordinates from sphereUV. At the poles u has no effect so a tangent is made up
+func sphereDerivatives(n vector3, r float64) (vector3, vector3) {
+	sinTheta := math.Sqrt(n.X*n.X + n.Y*n.Y)
+	if sinTheta < 1e-9 {
+		t, b := core.OrthonormalBasis(n)
+		return t, b
 	}
 
-	p := s.Add(d.Smult(lambda))
+	dpdu := vector3{-n.Y, n.X, 0}.Smult(2 * math.Pi * r)
+	dpdv := vector3{n.Z * n.X / sinTheta, n.Z * n.Y / sinTheta, -sinTheta}.Smult(math.Pi * r)
+	return dpdu, dpdv
+}
 
-	return &p
+// sphereUV maps a unit vector from the centre to longitude u and latitude v, both in [0, 1]
+// with the poles on the z axis
+func sphereUV(n vector3) (float64, float64) {
+	u := (math.Atan2(n.Y, n.X) + math.Pi) / (2 * math.Pi)
+	v := math.Acos(math.Max(-1, math.Min(1, n.Z))) / math.Pi
+	return u, v
 }
 
-// GetNormal gets the normal at the point p
-func (sphere *Sphere) GetNormal(p, _ vector3) vector3 {
-	return p.Subtract(sphere.Position).Normalize()
+// GetNormal gets the normal at the hit h
+func (sphere *Sphere) GetNormal(h *Hit, _ vector3) vector3 {
+	return h.ShadingNormal
 }
 
 // GetMaterial gets the mats.Material
 func (sphere *Sphere) GetMaterial() mats.Material {
 	return sphere.Mat
 }
+
+// GetBounds gets the box around the sphere
+func (sphere *Sphere) GetBounds() (core.AABB, bool) {
+	r := vector3{sphere.Radius, sphere.Radius, sphere.Radius}
+	return core.AABB{Min: sphere.Position.Subtract(r), Max: sphere.Position.Add(r)}, true
+}
@@ -2,131 +2,142 @@ package sobjs
 
 import (
 	"math"
-	"math/cmplx"
 
+	"github.com/benvardy/raytracing/core"
 	"github.com/benvardy/raytracing/mats"
 )
 
-func solveCubic(a, b, c float64) (complex128, complex128, complex128) {
-	var alpha, beta, gamma complex128
-	if c == 0 {
-		alpha = 0.0 + 0.0i
-		ca, cb := complex(a, 0.0), complex(b, 0.0)
-		beta = (-ca + cmplx.Sqrt(ca*ca-4.0*cb)) / 2.0
-		gamma = (-ca - cmplx.Sqrt(ca*ca-4.0*cb)) / 2.0
-	} else {
-		p := complex((3.0*b-a*a)/3.0, 0.0)
-		q := complex((9*b*a-27*c-2*math.Pow(a, 3))/27.0, 0.0)
-
-		tCubed := (q + cmplx.Sqrt(q*q+4.0*(cmplx.Pow(p, 3.0)/27.0))) / 2.0
-		t := cmplx.Pow(tCubed, 1.0/3.0)
-
-		alpha = t - p/(3.0*t) - complex(a/3.0, 0.0)
-
-		newB := complex(a, 0.0)*alpha + alpha*alpha
-		beta = (-newB + cmplx.Sqrt(newB*newB-4.0*alpha*complex(-c, 0.0))) / (2.0 * alpha)
-		gamma = (-newB - cmplx.Sqrt(newB*newB-4.0*alpha*complex(-c, 0.0))) / (2.0 * alpha)
-	}
-
-	return alpha, beta, gamma
-}
-
-// SolveQuartic https://en.wikipedia.org/wiki/Quartic_function#Ferrari's_solution
-func SolveQuartic(a, b, c, d float64) (complex128, complex128, complex128, complex128) {
-	var alpha, beta, gamma, delta complex128
-	if d == 0 {
-		alpha = 0
-		beta, gamma, delta = solveCubic(a, b, c)
-	} else {
-		p := (8*b - 3*a*a) / 8
-		q := (math.Pow(a, 3) - 4*a*b + 8*c) / 8
-		r := (-3*math.Pow(a, 4) + 256*d - 64*a*c + 16*a*a*b) / 256
-
-		// solve 8m^3 + 8pm^2 + (2p^2 - 8r)m - q^2 = 0
-
-		thing := (2*p*p - 8*r)
-		_ = thing
-		m1, m2, m3 := solveCubic(p, (2*p*p-8*r)/8, -(q*q)/8)
-		roots := []complex128{m1, m2, m3}
-		var m complex128
-		// Loop through the values of m and pick one that is non-0
-		for _, mi := range roots {
-			if mi != 0 {
-				m = mi
-				break
-			}
-		}
-
-		cp, cq, ca := complex(p, 0), complex(q, 0), complex(a, 0)
-		sqrtM := cmplx.Sqrt(m)
-
-		alpha = -ca/4 + (math.Sqrt2*sqrtM+cmplx.Sqrt(-(2*cp+2*m+(math.Sqrt2*cq)/sqrtM)))/2
-		beta = -ca/4 + (-math.Sqrt2*sqrtM+cmplx.Sqrt(-(2*cp+2*m-(math.Sqrt2*cq)/sqrtM)))/2
-		gamma = -ca/4 + (math.Sqrt2*sqrtM-cmplx.Sqrt(-(2*cp+2*m+(math.Sqrt2*cq)/sqrtM)))/2
-		delta = -ca/4 + (-math.Sqrt2*sqrtM-cmplx.Sqrt(-(2*cp+2*m-(math.Sqrt2*cq)/sqrtM)))/2
-	}
-
-	return alpha, beta, gamma, delta
-}
-
+// Torus is a ring SceneObject. The ring of radius BigR lies in the plane through Position
+// perpendicular to Axis, and the tube around it has radius LittleR
 type Torus struct {
 	Position vector3
 	Mat      mats.Material
 	BigR     float64
 	LittleR  float64
+	Axis     vector3
+
+	// tangent and bitangent complete the torus' frame with Axis
+	tangent, bitangent vector3
 }
 
-func NewTorus(pos vector3, R, r float64, mat mats.Material) *Torus {
-	return &Torus{pos, mat, R, r}
+// NewTorus creates a torus centred on pos around axis, with major radius R and minor radius r
+func NewTorus(pos, axis vector3, R, r float64, mat mats.Material) *Torus {
+	axis = axis.Normalize()
+	t, b := core.OrthonormalBasis(axis)
+	return &Torus{pos, mat, R, r, axis, t, b}
 }
 
-func (torus *Torus) IntersectWithRay(s, d vector3) *vector3 {
-	newS := torus.Position.Subtract(s)
+// toLocal converts a world vector to the torus' frame, where the axis is z
+func (torus *Torus) toLocal(v vector3) vector3 {
+	return vector3{v.Dot(torus.tangent), v.Dot(torus.bitangent), v.Dot(torus.Axis)}
+}
 
-	R, r := torus.BigR, torus.LittleR
-	a := math.Pow(d.X, 4) + math.Pow(d.Y, 4) + math.Pow(d.Z, 4) + 2*d.X*d.X*d.Y*d.Y + 2*d.X*d.X*d.Z*d.Z + 2*d.Y*d.Y*d.Z*d.Z
-	b := 4*math.Pow(d.X, 3)*s.X + 4*math.Pow(d.Y, 3)*s.Y + 4*math.Pow(d.Z, 3)*s.Z + 4*d.X*d.X*d.Y*s.Y + 4*d.X*d.X*d.Z*s.Z + 4*d.X*s.X*d.Y*d.Y + 4*d.Y*d.Y*d.Z*s.Z + 4*d.X*s.X*s.Z*s.Z + 4*d.Y*s.Y*s.Z*s.Z
-	c := -2*R*R*d.X*d.X - 2*R*R*d.Y*d.Y - 2*R*R*d.Z*d.Z - 2*r*r*d.X*d.X - 2*r*r*d.Y*d.Y - 2*r*r*d.Z*d.Z + 6*d.X*d.X*s.X*s.X + 2*s.X*s.X*d.Y*d.Y + 8*d.X*s.X*d.Y*s.Y + 2*d.X*d.X*s.Y*s.Y + 6*d.Y*d.Y*s.Y*s.Y + 2*s.X*s.X*d.Z*d.Z + 2*s.Y*s.Y*d.Z*d.Z + 8*d.X*s.X*d.Z*s.Z + 8*d.Y*s.Y*d.Z*s.Z + 2*d.X*d.X*d.Z*s.Z + 2*d.Y*d.Y*s.Z*s.Z + 6*d.Z*d.Z*s.Z*s.Z
-	e := -4*R*R*d.X*s.X - 4*R*R*d.Y*s.X + 4*R*R*d.X*s.X - 4*r*r*d.X*s.X - 4*r*r*d.Y*s.Y - 4*r*r*d.Z*s.Z + 4*d.X*s.X*s.X*s.X + 4*s.X*s.X*d.Y*s.Y + 4*d.X*s.X*s.Y*s.Y + 4*d.Y*s.Y*s.Y*s.Y + 4*s.X*s.X*d.Z*s.Z + 4*s.Y*s.Y*d.Z*s.Z + 4*s.Y*s.Y*d.Z*s.Z + 4*d.X*s.X*s.Z*s.Z + 4*d.Z*s.Z*s.Z*s.Z
-	f := math.Pow(R, 4) - 2*R*R*s.X*s.X - 2*R*R*s.Y*s.Y + 2*R*R*s.Z*s.Z + math.Pow(r, 4) - 2*r*r*R*R - 2*r*r*s.X*s.X - 2*r*r*s.Y*s.Y - 2*r*r*s.Z*s.Z + math.Pow(s.X, 4) + math.Pow(s.Y, 4) + math.Pow(s.Z, 4) + 2*s.X*s.X*s.Y*s.Y + 2*s.X*s.X*s.Z*s.Z + 2*s.Y*s.Y*s.Z*s.Z
+// toWorld converts a vector in the torus' frame back to world space
+func (torus *Torus) toWorld(v vector3) vector3 {
+	return torus.tangent.Smult(v.X).Add(torus.bitangent.Smult(v.Y)).Add(torus.Axis.Smult(v.Z))
+}
 
-	alf, bet, gam, del := SolveQuartic(b/a, c/a, e/a, f/a)
+// Intersect implements the SceneObject function. In the torus' frame the surface is
+// `(x^2 + y^2 + z^2 + R^2 - r^2)^2 = 4R^2(x^2 + y^2)`, substituting the ray gives a quartic in λ
+// which is solved only over the part of the ray inside the bounding sphere to keep the
+// coefficients well conditioned
+func (torus *Torus) Intersect(s, d vector3, tMin, tMax float64) *Hit {
+	R, r := torus.BigR, torus.LittleR
 
-	roots := []complex128{alf, bet, gam, del}
-	reRoots := make([]float64, 0)
+	ls := torus.toLocal(s.Subtract(torus.Position))
+	ld := torus.toLocal(d)
 
-	for _, root := range roots {
-		// *100000 for dec places
-		if math.Round(imag(root)*100000) == 0 && real(root) > 0 {
-			reRoots = append(reRoots, real(root))
-		}
+	// Clip the ray to the bounding sphere
+	G := ld.Dot(ld)
+	if G == 0 {
+		return nil
 	}
-
-	if len(reRoots) == 0 {
+	outer := R + r
+	half := ls.Dot(ld) / G
+	disc := half*half - (ls.Dot(ls)-outer*outer)/G
+	if disc < 0 {
+		return nil
+	}
+	enter, exit := -half-math.Sqrt(disc), -half+math.Sqrt(disc)
+	lo, hi := math.Max(enter, tMin), math.Min(exit, tMax)
+	if lo >= hi {
 		return nil
 	}
 
-	smallest := math.MaxFloat64
-	for _, root := range reRoots {
-		if root < smallest {
-			smallest = root
+	// Move the start to where the ray enters the sphere so the coefficients stay small,
+	// then solve for the offset μ = λ - t0
+	t0 := math.Max(enter, 0)
+	p := ls.Add(ld.Smult(t0))
+
+	H := 2 * p.Dot(ld)
+	K := p.Dot(p) + R*R - r*r
+	dxy := ld.X*ld.X + ld.Y*ld.Y
+	pdxy := p.X*ld.X + p.Y*ld.Y
+	pxy := p.X*p.X + p.Y*p.Y
+
+	roots := core.SolveQuartic(
+		G*G,
+		2*G*H,
+		H*H+2*G*K-4*R*R*dxy,
+		2*H*K-8*R*R*pdxy,
+		K*K-4*R*R*pxy,
+		lo-t0, hi-t0,
+	)
+
+	for _, mu := range roots {
+		lambda := mu + t0
+		if lambda <= tMin || lambda >= tMax {
+			continue
 		}
+
+		local := ls.Add(ld.Smult(lambda))
+		ln := torus.localNormal(local)
+		u, v := torus.localUV(local)
+
+		h := newHit(torus, s, d, lambda, torus.toWorld(ln), u, v)
+
+		// u moves around the ring and v around the tube, at right angles to it and the normal
+		around := vector3{-local.Y, local.X, 0}
+		h.DPDU = torus.toWorld(around.Smult(2 * math.Pi))
+		h.DPDV = torus.toWorld(ln.Cross(around.Normalize()).Smult(2 * math.Pi * r))
+		return h
 	}
 
-	pos := newS.Add(s.Smult(smallest))
-	return &pos
+	return nil
+}
+
+// localNormal returns the normal at the point p in the torus' frame. It points away from the
+// nearest point on the ring
+func (torus *Torus) localNormal(p vector3) vector3 {
+	ring := vector3{p.X, p.Y, 0}.Normalize().Smult(torus.BigR)
+	return p.Subtract(ring).Normalize()
 }
 
-func (torus *Torus) GetNormal(p, _ vector3) vector3 {
-	a, b, c := torus.Position.X, torus.Position.Y, torus.Position.Z
-	R := torus.BigR
-	x := -4 * (math.Pow(a, 3) - 2*a*a*p.X + a*(b*b-2*b*p.Y+c*c-2*c*p.Z-R*R) + 2*R*R*p.X)
-	y := -4 * (a*a*b - 2*a*b*p.X + b*b*b - 2*b*b*p.Y + b*(c*c-2*c*p.Z-R*R) + 2*R*R*p.Y)
-	z := -4 * c * (a*a - 2*a*p.X - 2*b*p.Y + c*c - 2*c*p.Z + R*R)
+// localUV returns the angle around the ring as u and around the tube as v, both in [0, 1]
+func (torus *Torus) localUV(p vector3) (float64, float64) {
+	u := (math.Atan2(p.Y, p.X) + math.Pi) / (2 * math.Pi)
+	v := (math.Atan2(p.Z, math.Sqrt(p.X*p.X+p.Y*p.Y)-torus.BigR) + math.Pi) / (2 * math.Pi)
+	return u, v
+}
 
-	return vector3{x, y, z}
+// GetNormal gets the normal at the hit h
+func (torus *Torus) GetNormal(h *Hit, _ vector3) vector3 {
+	return h.ShadingNormal
 }
 
+// GetMaterial gets the mats.Material
 func (torus *Torus) GetMaterial() mats.Material {
 	return torus.Mat
 }
+
+// GetBounds gets the box around the torus. On each axis the ring extends R * sqrt(1 - a_i^2)
+// and the tube adds r
+func (torus *Torus) GetBounds() (core.AABB, bool) {
+	a, R, r := torus.Axis, torus.BigR, torus.LittleR
+	e := vector3{
+		R*math.Sqrt(math.Max(0, 1-a.X*a.X)) + r,
+		R*math.Sqrt(math.Max(0, 1-a.Y*a.Y)) + r,
+		R*math.Sqrt(math.Max(0, 1-a.Z*a.Z)) + r,
+	}
+	return core.AABB{Min: torus.Position.Subtract(e), Max: torus.Position.Add(e)}, true
+}
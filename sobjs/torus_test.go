@@ -0,0 +1,210 @@
+package sobjs
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/benvardy/raytracing/mats"
+)
+
+// torusDistance returns the signed distance from p to the surface of torus, worked out from its
+// fields rather than its frame so it checks the frame too
+func torusDistance(torus *Torus, p vector3) float64 {
+	rel := p.Subtract(torus.Position)
+	z := rel.Dot(torus.Axis)
+	radial := rel.Subtract(torus.Axis.Smult(z)).Length()
+
+	return math.Hypot(radial-torus.BigR, z) - torus.LittleR
+}
+
+// torusGradient returns the normalised gradient of torusDistance at p by central differences
+func torusGradient(torus *Torus, p vector3) vector3 {
+	const h = 1e-6
+	dx, dy, dz := vector3{X: h}, vector3{Y: h}, vector3{Z: h}
+	return vector3{
+		torusDistance(torus, p.Add(dx)) - torusDistance(torus, p.Subtract(dx)),
+		torusDistance(torus, p.Add(dy)) - torusDistance(torus, p.Subtract(dy)),
+		torusDistance(torus, p.Add(dz)) - torusDistance(torus, p.Subtract(dz)),
+	}.Normalize()
+}
+
+// marchResult is what sphere tracing a ray against a torus found
+type marchResult struct {
+	hit bool
+	t   float64
+	// closest is the nearest the ray came to the surface without hitting it, and ambiguous is set
+	// if the march gave up, so rays that only just miss can be left out
+	closest   float64
+	ambiguous bool
+}
+
+// marchTorus sphere traces the ray s + λd, with d a unit vector, against torus up to tMax
+func marchTorus(torus *Torus, s, d vector3, tMax float64) marchResult {
+	res := marchResult{closest: math.Inf(1)}
+	t := 0.0
+	for i := 0; i < 1000000; i++ {
+		dist := math.Abs(torusDistance(torus, s.Add(d.Smult(t))))
+		if dist < 1e-12 {
+			res.hit, res.t = true, t
+			return res
+		}
+		res.closest = math.Min(res.closest, dist)
+
+		t += dist
+		if t > tMax {
+			return res
+		}
+	}
+
+	res.ambiguous = true
+	return res
+}
+
+// checkTorusRay compares Intersect against marching the ray s + λd, returning false if the ray
+// passes too close to the surface for the march to say whether it hits
+func checkTorusRay(t *testing.T, torus *Torus, s, d vector3) bool {
+	t.Helper()
+
+	d = d.Normalize()
+	tMax := s.Subtract(torus.Position).Length() + 2*(torus.BigR+torus.LittleR)
+	want := marchTorus(torus, s, d, tMax)
+	if want.ambiguous || (!want.hit && want.closest < 1e-6) {
+		return false
+	}
+
+	got := torus.Intersect(s, d, 1e-9, math.Inf(1))
+	switch {
+	case want.hit && got == nil:
+		t.Errorf("ray %v + λ%v: march hit at λ = %v, Intersect missed", s, d, want.t)
+		return true
+	case !want.hit && got != nil:
+		t.Errorf("ray %v + λ%v: march missed by %v, Intersect hit at λ = %v", s, d, want.closest, got.T)
+		return true
+	case !want.hit:
+		return true
+	}
+
+	scale := torus.BigR + torus.LittleR
+	if math.Abs(got.T-want.t) > 1e-7*scale {
+		t.Errorf("ray %v + λ%v: λ = %v, march found %v", s, d, got.T, want.t)
+	}
+
+	point := s.Add(d.Smult(want.t))
+	if dist := got.Point.Subtract(point).Length(); dist > 1e-7*scale {
+		t.Errorf("ray %v + λ%v: point %v is %v from march point %v", s, d, got.Point, dist, point)
+	}
+
+	if n := torusGradient(torus, got.Point); got.Normal.Subtract(n).Length() > 1e-4 {
+		t.Errorf("ray %v + λ%v: normal %v, gradient of the distance is %v", s, d, got.Normal, n)
+	}
+	return true
+}
+
+// randomUnit returns a random unit vector
+func randomUnit(rng *rand.Rand) vector3 {
+	for {
+		v := vector3{2*rng.Float64() - 1, 2*rng.Float64() - 1, 2*rng.Float64() - 1}
+		if l := v.Length(); l > 0.1 && l <= 1 {
+			return v.Smult(1 / l)
+		}
+	}
+}
+
+var testTori = []struct {
+	name string
+	pos  vector3
+	axis vector3
+	R, r float64
+}{
+	{"centred", vector3{}, vector3{Z: 1}, 2, 0.5},
+	{"translated", vector3{5, -3, 2}, vector3{Z: 1}, 2, 0.5},
+	{"rotated", vector3{}, vector3{1, 2, 3}, 3, 1},
+	{"translated and rotated", vector3{-4, 7, 1}, vector3{0, 1, -1}, 1.5, 0.25},
+	{"thin", vector3{1, 1, 1}, vector3{1, 0, 0}, 10, 0.1},
+}
+
+func TestTorusIntersectMatchesMarch(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	for _, tc := range testTori {
+		torus := NewTorus(tc.pos, tc.axis, tc.R, tc.r, mats.Material{})
+		outer := tc.R + tc.r
+
+		checked := 0
+		for i := 0; i < 500; i++ {
+			// Aim from well outside at a point in the bounding sphere
+			s := tc.pos.Add(randomUnit(rng).Smult(3 * outer))
+			target := tc.pos.Add(randomUnit(rng).Smult(outer * rng.Float64()))
+			if checkTorusRay(t, torus, s, target.Subtract(s)) {
+				checked++
+			}
+		}
+
+		if checked < 450 {
+			t.Errorf("%s: only %d of 500 rays could be checked", tc.name, checked)
+		}
+	}
+}
+
+func TestTorusIntersectFromInsideTube(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+
+	for _, tc := range testTori {
+		torus := NewTorus(tc.pos, tc.axis, tc.R, tc.r, mats.Material{})
+
+		for i := 0; i < 200; i++ {
+			// Start somewhere inside the tube
+			angle := 2 * math.Pi * rng.Float64()
+			ring := torus.tangent.Smult(math.Cos(angle)).Add(torus.bitangent.Smult(math.Sin(angle))).Smult(tc.R)
+			s := tc.pos.Add(ring).Add(randomUnit(rng).Smult(0.9 * tc.r * rng.Float64()))
+
+			d := randomUnit(rng)
+			checkTorusRay(t, torus, s, d)
+
+			hit := torus.Intersect(s, d, 1e-9, math.Inf(1))
+			if hit == nil {
+				t.Errorf("%s: ray from %v inside the tube missed", tc.name, s)
+			} else if hit.FrontFace {
+				t.Errorf("%s: ray from %v inside the tube hit the outside of it", tc.name, s)
+			}
+		}
+	}
+}
+
+func TestTorusIntersectGrazingHole(t *testing.T) {
+	for _, tc := range testTori {
+		torus := NewTorus(tc.pos, tc.axis, tc.R, tc.r, mats.Material{})
+		outer := tc.R + tc.r
+		inner := tc.R - tc.r
+
+		// Rays along the axis just inside and just outside the inner edge of the hole
+		for _, offset := range []float64{-1e-3, 1e-3} {
+			start := tc.pos.Add(torus.tangent.Smult(inner + offset*tc.r)).Subtract(torus.Axis.Smult(2 * outer))
+			hit := torus.Intersect(start, torus.Axis, 1e-9, math.Inf(1))
+
+			if offset < 0 && hit != nil {
+				t.Errorf("%s: ray through the hole %v inside the edge hit at λ = %v", tc.name, -offset*tc.r, hit.T)
+			}
+			if offset > 0 && hit == nil {
+				t.Errorf("%s: ray %v into the tube from the hole missed", tc.name, offset*tc.r)
+			}
+			checkTorusRay(t, torus, start, torus.Axis)
+		}
+
+		// Rays across the hole that just miss or just clip the top of the tube
+		for _, offset := range []float64{1e-3, -1e-3} {
+			height := torus.Axis.Smult(tc.r * (1 + offset))
+			start := tc.pos.Add(height).Subtract(torus.tangent.Smult(2 * outer))
+			hit := torus.Intersect(start, torus.tangent, 1e-9, math.Inf(1))
+
+			if offset > 0 && hit != nil {
+				t.Errorf("%s: ray %v above the tube hit at λ = %v", tc.name, offset*tc.r, hit.T)
+			}
+			if offset < 0 && hit == nil {
+				t.Errorf("%s: ray %v into the top of the tube missed", tc.name, -offset*tc.r)
+			}
+			checkTorusRay(t, torus, start, torus.tangent)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package sobjs
+
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/core"
+	"github.com/benvardy/raytracing/mats"
+)
+
+// Triangle is a triangle SceneObject. If vertex normals are given the normal is interpolated
+// across the face for smooth shading
+type Triangle struct {
+	V0, V1, V2 vector3
+	N0, N1, N2 vector3
+	Mat        mats.Material
+	// Smooth is set when N0, N1, N2 are vertex normals rather than the face normal
+	Smooth bool
+}
+
+// NewTriangle creates a flat shaded triangle from three vertices
+func NewTriangle(v0, v1, v2 vector3, material mats.Material) *Triangle {
+	n := v1.Subtract(v0).Cross(v2.Subtract(v0)).Normalize()
+	return &Triangle{v0, v1, v2, n, n, n, material, false}
+}
+
+// NewSmoothTriangle creates a triangle with a normal for each vertex
+func NewSmoothTriangle(v0, v1, v2, n0, n1, n2 vector3, material mats.Material) *Triangle {
+	return &Triangle{v0, v1, v2, n0.Normalize(), n1.Normalize(), n2.Normalize(), material, true}
+}
+
+// Intersect implements the SceneObject function
+func (tri *Triangle) Intersect(s, d vector3, tMin, tMax float64) *Hit {
+	lambda, u, v, ok := intersectTriangle(tri.V0, tri.V1, tri.V2, s, d, tMin, tMax)
+	if !ok {
+		return nil
+	}
+
+	h := newHit(tri, s, d, lambda, faceNormal(tri.V0, tri.V1, tri.V2), u, v)
+	h.DPDU, h.DPDV = tri.V1.Subtract(tri.V0), tri.V2.Subtract(tri.V0)
+	if tri.Smooth {
+		h.ShadingNormal = interpolate(tri.N0, tri.N1, tri.N2, u, v).Normalize()
+	}
+	return h
+}
+
+// GetNormal gets the normal at the hit h, flipped to face the light l like Disk
+func (tri *Triangle) GetNormal(h *Hit, l vector3) vector3 {
+	return h.FacingNormal(l)
+}
+
+// GetMaterial gets the mats.Material
+func (tri *Triangle) GetMaterial() mats.Material {
+	return tri.Mat
+}
+
+// GetBounds gets the box around the three vertices
+func (tri *Triangle) GetBounds() (core.AABB, bool) {
+	return triangleBounds(tri.V0, tri.V1, tri.V2), true
+}
+
+// intersectTriangle uses the Möller–Trumbore algorithm to find λ in the range (tMin, tMax) where
+// s + λd crosses the triangle, along with the barycentric coordinates u, v of the hit
+func intersectTriangle(v0, v1, v2, s, d vector3, tMin, tMax float64) (float64, float64, float64, bool) {
+	const epsilon = 1e-12
+
+	e1 := v1.Subtract(v0)
+	e2 := v2.Subtract(v0)
+
+	pvec := d.Cross(e2)
+	det := e1.Dot(pvec)
+	// The ray is parallel to the triangle
+	if math.Abs(det) < epsilon {
+		return 0, 0, 0, false
+	}
+	invDet := 1 / det
+
+	tvec := s.Subtract(v0)
+	u := tvec.Dot(pvec) * invDet
+	if u < 0 || u > 1 {
+		return 0, 0, 0, false
+	}
+
+	qvec := tvec.Cross(e1)
+	v := d.Dot(qvec) * invDet
+	if v < 0 || u+v > 1 {
+		return 0, 0, 0, false
+	}
+
+	lambda := e2.Dot(qvec) * invDet
+	if lambda <= tMin || lambda >= tMax {
+		return 0, 0, 0, false
+	}
+
+	return lambda, u, v, true
+}
+
+// faceNormal returns the normal of the triangle, facing the side its vertices go anticlockwise on
+func faceNormal(v0, v1, v2 vector3) vector3 {
+	return v1.Subtract(v0).Cross(v2.Subtract(v0)).Normalize()
+}
+
+// interpolate blends per vertex values with the barycentric weights u of a1 and v of a2
+func interpolate(a0, a1, a2 vector3, u, v float64) vector3 {
+	return a0.Smult(1 - u - v).Add(a1.Smult(u)).Add(a2.Smult(v))
+}
+
+func triangleBounds(v0, v1, v2 vector3) core.AABB {
+	return core.AABB{Min: v0, Max: v0}.AddPoint(v1).AddPoint(v2)
+}
+
+// uvDerivatives returns how the point on the triangle p0 p1 p2 moves with the texture
+// coordinates uv0 uv1 uv2 at its corners. It returns false if the coordinates are degenerate
+func uvDerivatives(p0, p1, p2, uv0, uv1, uv2 vector3) (vector3, vector3, bool) {
+	du1, dv1 := uv1.X-uv0.X, uv1.Y-uv0.Y
+	du2, dv2 := uv2.X-uv0.X, uv2.Y-uv0.Y
+	det := du1*dv2 - dv1*du2
+	if math.Abs(det) < 1e-12 {
+		return vector3{}, vector3{}, false
+	}
+
+	e1, e2 := p1.Subtract(p0), p2.Subtract(p0)
+	dpdu := e1.Smult(dv2).Subtract(e2.Smult(dv1)).Smult(1 / det)
+	dpdv := e2.Smult(du1).Subtract(e1.Smult(du2)).Smult(1 / det)
+	return dpdu, dpdv, true
+}
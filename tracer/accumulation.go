@@ -0,0 +1,173 @@
+package tracer
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+
+	"github.com/benvardy/raytracing/core"
+)
+
+// Accumulation is the running total of the samples taken in every pixel of a render. Passes
+// of TraceProgressive add to it, and it can be saved as a checkpoint and loaded to carry on
+// rendering later. The slices hold the pixels a row at a time like core.Framebuffer
+type Accumulation struct {
+	Width, Height int
+	// Passes is the number of passes added so far
+	Passes int
+
+	// Scene is the Source of the scene the samples were taken of, and Camera describes the camera
+	// and lens they were seen through
+	Scene  string
+	Camera string
+
+	// The settings the samples were taken with, which passes added later must share
+	Seed              int64
+	PixelSamples      int
+	Pattern           SamplePattern
+	Filter            PixelFilter
+	AdaptiveThreshold float64
+	DOF               bool
+	LensSamples       int
+	Shading           bool
+	Mode              RenderMode
+	Samples           int
+	MaxDepth          int
+	RussianRoulette   bool
+	RouletteDepth     int
+	// Centre is set if each pixel took its one sample in the middle, which no more passes can
+	// be added to
+	Centre bool
+
+	// Sum and Weight are the filtered samples spread into each pixel and their total weight.
+	// Box is the sum of the samples taken in each pixel, which is used instead if the negative
+	// lobes of the filter leave the pixel with no weight
+	Sum    []core.Vector3
+	Weight []float64
+	Box    []core.Vector3
+	// Count is the number of samples taken in each pixel, with Mean and M2 the mean and sum of
+	// squared differences of their luminance used by adaptive sampling
+	Count []int
+	Mean  []float64
+	M2    []float64
+}
+
+// NewAccumulation creates an empty Accumulation for a width x height render
+func NewAccumulation(width, height int) *Accumulation {
+	n := width * height
+	return &Accumulation{
+		Width:  width,
+		Height: height,
+		Sum:    make([]core.Vector3, n),
+		Weight: make([]float64, n),
+		Box:    make([]core.Vector3, n),
+		Count:  make([]int, n),
+		Mean:   make([]float64, n),
+		M2:     make([]float64, n),
+	}
+}
+
+// LoadAccumulation reads an Accumulation written by Save
+func LoadAccumulation(r io.Reader) (*Accumulation, error) {
+	var a Accumulation
+	if err := gob.NewDecoder(r).Decode(&a); err != nil {
+		return nil, err
+	}
+
+	n := a.Width * a.Height
+	if len(a.Sum) != n || len(a.Weight) != n || len(a.Box) != n || len(a.Count) != n || len(a.Mean) != n || len(a.M2) != n {
+		return nil, fmt.Errorf("checkpoint for %dx%d pixels has the wrong number of pixels", a.Width, a.Height)
+	}
+
+	return &a, nil
+}
+
+// Save writes a to w so it can be loaded by LoadAccumulation
+func (a *Accumulation) Save(w io.Writer) error {
+	return gob.NewEncoder(w).Encode(a)
+}
+
+// Compatible returns an error if passes of scene rendered with opts can't be added to a, as
+// the image would no longer match a render done in one go
+func (a *Accumulation) Compatible(scene *Scene, opts RenderOptions) error {
+	switch {
+	case a.Width != scene.ScreenWidth || a.Height != scene.ScreenHeight:
+		return fmt.Errorf("checkpoint is %dx%d, not %dx%d", a.Width, a.Height, scene.ScreenWidth, scene.ScreenHeight)
+	case a.Passes == 0:
+		return nil
+	case a.Scene != scene.Source:
+		return fmt.Errorf("checkpoint was rendered of scene %.12s, not %.12s", a.Scene, scene.Source)
+	case a.Camera != describeCamera(scene):
+		return fmt.Errorf("checkpoint was rendered through camera %s, not %s", a.Camera, describeCamera(scene))
+	case a.Seed != opts.Seed:
+		return fmt.Errorf("checkpoint was rendered with seed %d, not %d", a.Seed, opts.Seed)
+	case a.PixelSamples != opts.PixelSamples:
+		return fmt.Errorf("checkpoint was rendered with %d pixel samples a pass, not %d", a.PixelSamples, opts.PixelSamples)
+	case a.Pattern != opts.Pattern:
+		return fmt.Errorf("checkpoint was rendered with the %s pattern, not %s", a.Pattern, opts.Pattern)
+	case a.Filter != opts.Filter:
+		return fmt.Errorf("checkpoint was rendered with the %s filter, not %s", a.Filter, opts.Filter)
+	case a.AdaptiveThreshold != opts.AdaptiveThreshold:
+		return fmt.Errorf("checkpoint was rendered with adaptive threshold %v, not %v", a.AdaptiveThreshold, opts.AdaptiveThreshold)
+	case a.DOF != opts.DOF:
+		return fmt.Errorf("checkpoint was rendered with depth of field %v, not %v", a.DOF, opts.DOF)
+	case a.DOF && a.LensSamples != opts.LensSamples:
+		return fmt.Errorf("checkpoint was rendered with %d lens samples, not %d", a.LensSamples, opts.LensSamples)
+	case a.Shading != opts.Shading:
+		return fmt.Errorf("checkpoint was rendered with distributed shading %v, not %v", a.Shading, opts.Shading)
+	case a.Mode != opts.Mode:
+		return fmt.Errorf("checkpoint was rendered in %s mode, not %s", a.Mode, opts.Mode)
+	case a.Mode == ModePath && a.Samples != opts.Samples:
+		return fmt.Errorf("checkpoint was rendered with %d samples a ray, not %d", a.Samples, opts.Samples)
+	case a.MaxDepth != opts.MaxDepth:
+		return fmt.Errorf("checkpoint was rendered with max depth %d, not %d", a.MaxDepth, opts.MaxDepth)
+	case a.RussianRoulette != opts.RussianRoulette:
+		return fmt.Errorf("checkpoint was rendered with Russian roulette %v, not %v", a.RussianRoulette, opts.RussianRoulette)
+	case a.RussianRoulette && a.RouletteDepth != opts.RouletteDepth:
+		return fmt.Errorf("checkpoint was rendered with roulette depth %d, not %d", a.RouletteDepth, opts.RouletteDepth)
+	case a.Centre && !centreSampled(opts):
+		return fmt.Errorf("checkpoint took 1 sample in the centre of each pixel, so no more passes can be added to it")
+	}
+	return nil
+}
+
+// record stores the scene, camera and settings of opts that passes added to a must share
+func (a *Accumulation) record(scene *Scene, opts RenderOptions) {
+	a.Scene, a.Camera = scene.Source, describeCamera(scene)
+	a.Seed, a.PixelSamples, a.Pattern, a.Filter = opts.Seed, opts.PixelSamples, opts.Pattern, opts.Filter
+	a.AdaptiveThreshold, a.DOF, a.LensSamples, a.Shading = opts.AdaptiveThreshold, opts.DOF, opts.LensSamples, opts.Shading
+	a.Mode, a.Samples, a.MaxDepth = opts.Mode, opts.Samples, opts.MaxDepth
+	a.RussianRoulette, a.RouletteDepth = opts.RussianRoulette, opts.RouletteDepth
+	a.Centre = centreSampled(opts)
+}
+
+// describeCamera returns the type and settings of the camera and lens scene is seen through
+func describeCamera(scene *Scene) string {
+	return fmt.Sprintf("%T %+v, lens %+v", scene.Camera, scene.Camera, scene.Lens)
+}
+
+// Resolve writes the filtered colour of every pixel so far to fb. A pixel whose weight isn't
+// positive, which the Mitchell filter can give when the samples around it outnumber its own,
+// falls back to the average of the samples taken in it as a box filter would
+func (a *Accumulation) Resolve(fb *core.Framebuffer) {
+	for i := range fb.Pix {
+		c := vector3{}
+		if wt := a.Weight[i]; wt > 0 {
+			c = a.Sum[i].Smult(1 / wt)
+		} else if a.Count[i] > 0 {
+			c = a.Box[i].Smult(1 / float64(a.Count[i]))
+		}
+		fb.Pix[i] = c
+	}
+}
+
+// add adds the samples in a tile image to the totals
+func (a *Accumulation) add(img *tileImage) {
+	for y := img.y0; y < img.y1; y++ {
+		for x := img.x0; x < img.x1; x++ {
+			i := (y-img.y0)*(img.x1-img.x0) + x - img.x0
+			a.Sum[y*a.Width+x] = a.Sum[y*a.Width+x].Add(img.sum[i])
+			a.Weight[y*a.Width+x] += img.weight[i]
+		}
+	}
+}
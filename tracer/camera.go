@@ -0,0 +1,147 @@
+package tracer
+
+import "math"
+
+// Camera turns points on the image into the rays traced through them
+type Camera interface {
+	// Ray returns the start and unit direction of the ray through (x, y), measured in pixels
+	// from the top left corner of the image. It returns false if the camera sees nothing there
+	Ray(x, y float64) (vector3, vector3, bool)
+	// Frame returns where the camera is and which way it faces
+	Frame() CameraFrame
+}
+
+// CameraFrame is the position of a camera and the unit vectors it looks along, with Left and
+// Up across the image
+type CameraFrame struct {
+	Eye, Look, Left, Up vector3
+}
+
+// NewCameraFrame creates the frame for a camera at eye looking at target, turned so that up
+// points up the image. up must not be parallel to the direction of target
+func NewCameraFrame(eye, target, up vector3) CameraFrame {
+	look := target.Subtract(eye).Normalize()
+	left := up.Cross(look).Normalize()
+
+	return CameraFrame{eye, look, left, look.Cross(left)}
+}
+
+// Frame returns f, so cameras can embed their frame
+func (f CameraFrame) Frame() CameraFrame {
+	return f
+}
+
+// toWorld returns the direction with coordinates (x, y, z) to the right, up and forwards
+func (f CameraFrame) toWorld(x, y, z float64) vector3 {
+	return f.Left.Smult(-x).Add(f.Up.Smult(y)).Add(f.Look.Smult(z))
+}
+
+// imagePlane returns (x, y) as coordinates from the centre of a width x height image, scaled
+// so the height runs from -1 at the bottom to 1 at the top
+func imagePlane(x, y float64, width, height int) (float64, float64) {
+	h := float64(height) / 2
+	return (x - float64(width)/2) / h, (h - y) / h
+}
+
+// Perspective is a pinhole camera, VFOV is the angle in radians between the top and bottom of
+// the image. The horizontal angle follows from the shape of the image
+type Perspective struct {
+	CameraFrame
+	VFOV          float64
+	Width, Height int
+}
+
+// NewPerspective creates a perspective camera at eye looking at target with a vertical field
+// of view of vfov degrees, for a width x height image
+func NewPerspective(eye, target, up vector3, vfov float64, width, height int) *Perspective {
+	return &Perspective{NewCameraFrame(eye, target, up), vfov * math.Pi / 180, width, height}
+}
+
+// Ray implements Camera
+func (c *Perspective) Ray(x, y float64) (vector3, vector3, bool) {
+	u, v := imagePlane(x, y, c.Width, c.Height)
+	scale := math.Tan(c.VFOV / 2)
+
+	return c.Eye, c.toWorld(u*scale, v*scale, 1).Normalize(), true
+}
+
+// Orthographic is a camera whose rays are parallel, so objects stay the same size however far
+// away they are. ViewHeight is the distance between the top and bottom of the image
+type Orthographic struct {
+	CameraFrame
+	ViewHeight    float64
+	Width, Height int
+}
+
+// NewOrthographic creates an orthographic camera centred on eye looking towards target, that
+// sees viewHeight units from the top to the bottom of a width x height image
+func NewOrthographic(eye, target, up vector3, viewHeight float64, width, height int) *Orthographic {
+	return &Orthographic{NewCameraFrame(eye, target, up), viewHeight, width, height}
+}
+
+// Ray implements Camera
+func (c *Orthographic) Ray(x, y float64) (vector3, vector3, bool) {
+	u, v := imagePlane(x, y, c.Width, c.Height)
+	half := c.ViewHeight / 2
+
+	return c.Eye.Add(c.toWorld(u*half, v*half, 0)), c.Look, true
+}
+
+// Fisheye is an equidistant fisheye camera, where the angle from Look grows evenly with the
+// distance from the centre of the image. FOV is the angle in radians across the circle that
+// fits the shorter side of the image, nothing is seen outside it
+type Fisheye struct {
+	CameraFrame
+	FOV           float64
+	Width, Height int
+}
+
+// NewFisheye creates a fisheye camera at eye looking at target which sees fov degrees across,
+// for a width x height image
+func NewFisheye(eye, target, up vector3, fov float64, width, height int) *Fisheye {
+	return &Fisheye{NewCameraFrame(eye, target, up), fov * math.Pi / 180, width, height}
+}
+
+// Ray implements Camera
+func (c *Fisheye) Ray(x, y float64) (vector3, vector3, bool) {
+	u, v := imagePlane(x, y, c.Width, c.Height)
+	if c.Width < c.Height {
+		// Fit the circle to the width instead
+		aspect := float64(c.Height) / float64(c.Width)
+		u, v = u*aspect, v*aspect
+	}
+
+	r := math.Sqrt(u*u + v*v)
+	if r > 1 {
+		return vector3{}, vector3{}, false
+	}
+
+	if r == 0 {
+		return c.Eye, c.Look, true
+	}
+	theta := r * c.FOV / 2
+	sinTheta := math.Sin(theta) / r
+
+	return c.Eye, c.toWorld(u*sinTheta, v*sinTheta, math.Cos(theta)).Normalize(), true
+}
+
+// Equirect is a 360° camera which sees every direction, laid out with longitude across the
+// image and latitude down it. Look is at the centre of the image and Up at the top edge
+type Equirect struct {
+	CameraFrame
+	Width, Height int
+}
+
+// NewEquirect creates a 360° camera at eye with target in the centre of a width x height image
+func NewEquirect(eye, target, up vector3, width, height int) *Equirect {
+	return &Equirect{NewCameraFrame(eye, target, up), width, height}
+}
+
+// Ray implements Camera
+func (c *Equirect) Ray(x, y float64) (vector3, vector3, bool) {
+	phi := (x/float64(c.Width) - 0.5) * 2 * math.Pi
+	theta := (0.5 - y/float64(c.Height)) * math.Pi
+
+	cosTheta := math.Cos(theta)
+	return c.Eye, c.toWorld(cosTheta*math.Sin(phi), math.Sin(theta), cosTheta*math.Cos(phi)).Normalize(), true
+}
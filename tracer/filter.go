@@ -0,0 +1,129 @@
+package tracer
+
+import (
+	"fmt"
+	"math"
+)
+
+// PixelFilter weighs the samples around a pixel to build its colour, trading sharpness
+// against aliasing
+type PixelFilter int
+
+const (
+	// FilterBox averages the samples inside the pixel
+	FilterBox PixelFilter = iota
+	// FilterTent falls off linearly to 1 pixel from the centre
+	FilterTent
+	// FilterGaussian is a Gaussian with alpha 2 cut off 1.5 pixels from the centre, which is
+	// smooth but a little soft
+	FilterGaussian
+	// FilterMitchell is the Mitchell–Netravali filter with B = C = 1/3 reaching 2 pixels from
+	// the centre. Its negative lobes keep edges sharp
+	FilterMitchell
+)
+
+var pixelFilterNames = []string{"box", "tent", "gaussian", "mitchell"}
+
+func (f PixelFilter) String() string {
+	if int(f) < len(pixelFilterNames) {
+		return pixelFilterNames[f]
+	}
+	return fmt.Sprintf("PixelFilter(%d)", int(f))
+}
+
+// ParsePixelFilter returns the PixelFilter called name
+func ParsePixelFilter(name string) (PixelFilter, error) {
+	for i, n := range pixelFilterNames {
+		if n == name {
+			return PixelFilter(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown pixel filter %q, want box, tent, gaussian or mitchell", name)
+}
+
+// radius returns how far in pixels from a sample the filter reaches
+func (f PixelFilter) radius() float64 {
+	switch f {
+	case FilterTent:
+		return 1
+	case FilterGaussian:
+		return 1.5
+	case FilterMitchell:
+		return 2
+	}
+	return 0.5
+}
+
+// weight returns how much a sample dx, dy pixels from the centre of a pixel counts towards it
+func (f PixelFilter) weight(dx, dy float64) float64 {
+	return f.weight1D(dx) * f.weight1D(dy)
+}
+
+func (f PixelFilter) weight1D(x float64) float64 {
+	r := f.radius()
+	switch f {
+	case FilterTent:
+		return math.Max(0, r-math.Abs(x))
+	case FilterGaussian:
+		const alpha = 2
+		return math.Max(0, math.Exp(-alpha*x*x)-math.Exp(-alpha*r*r))
+	case FilterMitchell:
+		const b, c = 1.0 / 3, 1.0 / 3
+		x = math.Abs(2 * x / r)
+		switch {
+		case x < 1:
+			return ((12-9*b-6*c)*x*x*x + (-18+12*b+6*c)*x*x + (6 - 2*b)) / 6
+		case x < 2:
+			return ((-b-6*c)*x*x*x + (6*b+30*c)*x*x + (-12*b-48*c)*x + (8*b + 24*c)) / 6
+		}
+		return 0
+	}
+
+	// Half open so a sample on the edge of two pixels only counts towards one
+	if x >= -r && x < r {
+		return 1
+	}
+	return 0
+}
+
+// tileImage collects the filtered samples taken in a tile. It covers the pixels of the tile and
+// a margin around it that samples near the edge spread into
+type tileImage struct {
+	x0, y0, x1, y1 int
+	sum            []vector3
+	weight         []float64
+}
+
+// newTileImage creates an empty image covering t and margin pixels around it that are inside a
+// width x height image
+func newTileImage(t tile, margin, width, height int) *tileImage {
+	x0 := int(math.Max(float64(t.x0-margin), 0))
+	y0 := int(math.Max(float64(t.y0-margin), 0))
+	x1 := int(math.Min(float64(t.x1+margin), float64(width)))
+	y1 := int(math.Min(float64(t.y1+margin), float64(height)))
+	n := (x1 - x0) * (y1 - y0)
+
+	return &tileImage{x0, y0, x1, y1, make([]vector3, n), make([]float64, n)}
+}
+
+// splat adds the sample c taken at (x, y) in the image to every pixel f reaches
+func (img *tileImage) splat(x, y float64, c vector3, f PixelFilter) {
+	r := f.radius()
+	px0 := int(math.Max(math.Ceil(x-0.5-r), float64(img.x0)))
+	py0 := int(math.Max(math.Ceil(y-0.5-r), float64(img.y0)))
+	px1 := int(math.Min(math.Floor(x-0.5+r)+1, float64(img.x1)))
+	py1 := int(math.Min(math.Floor(y-0.5+r)+1, float64(img.y1)))
+
+	for py := py0; py < py1; py++ {
+		for px := px0; px < px1; px++ {
+			wt := f.weight(x-float64(px)-0.5, y-float64(py)-0.5)
+			if wt == 0 {
+				continue
+			}
+
+			i := (py-img.y0)*(img.x1-img.x0) + px - img.x0
+			img.sum[i] = img.sum[i].Add(c.Smult(wt))
+			img.weight[i] += wt
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package tracer
+
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/core"
+)
+
+// heatmapColors run from the fewest samples to the most, as they are shown on screen
+var heatmapColors = []vector3{{0, 0, 0.5}, {0, 0.5, 1}, {0, 1, 0}, {1, 1, 0}, {1, 0, 0}}
+
+// SampleHeatmap fills fb with a picture of the number of samples each pixel took, as returned
+// by Trace. Pixels go from dark blue for the fewest samples to red for the most
+func SampleHeatmap(counts []int, fb *core.Framebuffer) {
+	least, most := math.MaxInt32, 0
+	for _, n := range counts {
+		if n < least {
+			least = n
+		}
+		if n > most {
+			most = n
+		}
+	}
+
+	for i, n := range counts {
+		t := 0.0
+		if most > least {
+			t = float64(n-least) / float64(most-least)
+		}
+
+		// Blend between the two nearest colours
+		f := t * float64(len(heatmapColors)-1)
+		j := int(math.Min(f, float64(len(heatmapColors)-2)))
+		a, b := heatmapColors[j], heatmapColors[j+1]
+		c := a.Smult(1 - (f - float64(j))).Add(b.Smult(f - float64(j)))
+
+		// The colours are picked for display so are decoded to the linear radiance fb holds
+		fb.Pix[i] = vector3{X: core.SRGBDecode(c.X), Y: core.SRGBDecode(c.Y), Z: core.SRGBDecode(c.Z)}
+	}
+}
@@ -0,0 +1,86 @@
+package tracer
+
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/lights"
+)
+
+// Lens is a thin lens over the front of a camera. Rays leave from points across its aperture
+// and meet again FocusDistance in front of the camera, so only things at that depth are sharp
+type Lens struct {
+	// Radius is the radius of the aperture
+	Radius float64
+	// FocusDistance is the distance along the camera's Look to the plane in focus
+	FocusDistance float64
+	// Blades is the number of sides of a polygonal aperture, a round one is used below 3.
+	// BladeRotation turns the polygon in radians
+	Blades        int
+	BladeRotation float64
+	// CatsEye from 0 to 1 is how much the lens barrel cuts off the aperture towards the edges of
+	// the image, squashing out of focus highlights into cat's eyes and darkening the corners
+	CatsEye float64
+}
+
+// NewLens creates a round lens with an aperture of the given radius, focused at focusDistance
+func NewLens(radius, focusDistance float64) *Lens {
+	return &Lens{Radius: radius, FocusDistance: focusDistance}
+}
+
+// FStopRadius returns the aperture radius of a lens with the given focal length and f-number
+func FStopRadius(focalLength, fStop float64) float64 {
+	return focalLength / (2 * fStop)
+}
+
+// FocusOn returns the focus distance that puts the point p in focus for a camera with frame f
+func FocusOn(f CameraFrame, p vector3) float64 {
+	return p.Subtract(f.Eye).Dot(f.Look)
+}
+
+// Ray returns the ray through the lens that replaces the pinhole ray origin + λd of a camera
+// with frame f, leaving from the point on the aperture picked by u1 and u2. px and py are
+// where the pixel is on the image, with the corners 1 from the centre. It returns false if the
+// lens barrel blocks the ray. Rays that don't go forwards can't pass through the lens and are
+// returned as they are
+func (l *Lens) Ray(f CameraFrame, origin, d vector3, px, py, u1, u2 float64) (vector3, vector3, bool) {
+	cos := d.Dot(f.Look)
+	if cos <= 0 {
+		return origin, d, true
+	}
+
+	a, b := l.aperture(u1, u2)
+
+	// The barrel is another opening as big as the aperture, which moves off centre as the pixel
+	// does
+	shift := 2 * l.Radius * l.CatsEye
+	if da, db := a-px*shift, b-py*shift; da*da+db*db > l.Radius*l.Radius {
+		return vector3{}, vector3{}, false
+	}
+
+	focus := origin.Add(d.Smult(l.FocusDistance / cos))
+	start := origin.Add(f.toWorld(a, b, 0))
+
+	return start, focus.Subtract(start).Normalize(), true
+}
+
+// aperture maps u1 and u2 evenly onto the opening of the lens, returning how far right and up
+// of its centre the point is
+func (l *Lens) aperture(u1, u2 float64) (float64, float64) {
+	if l.Blades < 3 {
+		x, y := lights.ConcentricDisk(u1, u2)
+		return x * l.Radius, y * l.Radius
+	}
+
+	// Pick one of the triangles between the centre and each blade, then a point in it
+	n := float64(l.Blades)
+	side := math.Min(math.Floor(u1*n), n-1)
+	u1 = u1*n - side
+
+	theta0 := l.BladeRotation + 2*math.Pi*side/n
+	theta1 := theta0 + 2*math.Pi/n
+
+	s := math.Sqrt(u1) * l.Radius
+	x := (1-u2)*math.Cos(theta0) + u2*math.Cos(theta1)
+	y := (1-u2)*math.Sin(theta0) + u2*math.Sin(theta1)
+	return x * s, y * s
+}
@@ -0,0 +1,1120 @@
+package tracer
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+
+	"github.com/benvardy/raytracing/core"
+	"github.com/benvardy/raytracing/lights"
+	"github.com/benvardy/raytracing/mats"
+	"github.com/benvardy/raytracing/sobjs"
+)
+
+// sceneFile is the layout of a JSON scene description
+type sceneFile struct {
+	Camera    json.RawMessage            `json:"camera"`
+	Ambient   *[3]float64                `json:"ambient"`
+	Textures  map[string]json.RawMessage `json:"textures"`
+	Materials map[string]json.RawMessage `json:"materials"`
+	Objects   []json.RawMessage          `json:"objects"`
+	Lights    []json.RawMessage          `json:"lights"`
+	// Environment is seen by rays that miss and lights the scene, it is black if missing
+	Environment json.RawMessage `json:"environment"`
+}
+
+// cameraFile describes the Camera and depth of field arguments to NewScene. Type picks the
+// camera, with a perspective camera used if it is empty. Angles are in degrees
+type cameraFile struct {
+	Type   string      `json:"type"`
+	Eye    *[3]float64 `json:"eye"`
+	Target *[3]float64 `json:"target"`
+	Up     *[3]float64 `json:"up"`
+	// FOV is the vertical field of view of a perspective camera, or the angle across the image
+	// circle of a fisheye one
+	FOV *float64 `json:"fov"`
+	// Height is the height of the area an orthographic camera sees
+	Height *float64 `json:"height"`
+	// The lens is given by ApertureRadius or by FStop and FocalLength. It focuses at
+	// FocusDistance or on FocusPoint, and at the distance to Target if neither is set
+	ApertureRadius *float64    `json:"apertureRadius"`
+	FStop          *float64    `json:"fStop"`
+	FocalLength    *float64    `json:"focalLength"`
+	FocusDistance  *float64    `json:"focusDistance"`
+	FocusPoint     *[3]float64 `json:"focusPoint"`
+	Blades         *int        `json:"blades"`
+	BladeRotation  *float64    `json:"bladeRotation"`
+	CatsEye        *float64    `json:"catsEye"`
+}
+
+// materialFile describes a mats.Material. Type picks a BSDF, with the Phong parameters used
+// if it is empty
+type materialFile struct {
+	Type         string      `json:"type"`
+	Ka           *[3]float64 `json:"ka"`
+	Kd           *[3]float64 `json:"kd"`
+	Ks           *[3]float64 `json:"ks"`
+	Roughness    *float64    `json:"roughness"`
+	Reflectivity *float64    `json:"reflectivity"`
+	IOR          *float64    `json:"ior"`
+	Transmission *float64    `json:"transmission"`
+	Absorption   *[3]float64 `json:"absorption"`
+	// Color is the albedo, the F0 of a conductor or the tint of a dielectric
+	Color    *[3]float64 `json:"color"`
+	Metallic *float64    `json:"metallic"`
+	// The maps name textures that multiply the parameters, see mats.Material
+	KdMap           string `json:"kdMap"`
+	KsMap           string `json:"ksMap"`
+	ReflectivityMap string `json:"reflectivityMap"`
+	RoughnessMap    string `json:"roughnessMap"`
+	NormalMap       string `json:"normalMap"`
+	BumpMap         string `json:"bumpMap"`
+	// BumpScale is the height of white in the bump map, it defaults to 1
+	BumpScale *float64 `json:"bumpScale"`
+	// Emission is the radiance given off by any type of material, EmissionSamples is the
+	// number of shadow rays for each point it lights and defaults to 16
+	Emission        *[3]float64 `json:"emission"`
+	EmissionSamples *int        `json:"emissionSamples"`
+}
+
+// textureFile describes a mats.Texture, which fields are needed depends on Type
+type textureFile struct {
+	Type string `json:"type"`
+	// File is the image of an image texture, relative to the scene file
+	File string `json:"file"`
+	Wrap string `json:"wrap"`
+	// SRGB is whether the image is stored with the sRGB gamma, it defaults to true
+	SRGB  *bool       `json:"srgb"`
+	Scale *float64    `json:"scale"`
+	Color *[3]float64 `json:"color"`
+	// Colors are the two colours of a checker or marble texture
+	Colors     *[2][3]float64 `json:"colors"`
+	Turbulence *float64       `json:"turbulence"`
+	Seed       *int64         `json:"seed"`
+}
+
+// environmentFile describes a lights.Environment, which fields are needed depends on Type
+type environmentFile struct {
+	Type string `json:"type"`
+	// File is the equirectangular .hdr or .exr image of an image environment, relative to the
+	// scene file
+	File string `json:"file"`
+	// Scale multiplies the radiance of an image or the luminance of a sun and sky
+	Scale *float64 `json:"scale"`
+	// Rotation turns an image about the vertical axis, in degrees
+	Rotation *float64    `json:"rotation"`
+	Zenith   *[3]float64 `json:"zenith"`
+	Horizon  *[3]float64 `json:"horizon"`
+	Ground   *[3]float64 `json:"ground"`
+	// Sun points towards the sun, Angle is the angular radius of its disc in degrees
+	Sun          *[3]float64 `json:"sun"`
+	Turbidity    *float64    `json:"turbidity"`
+	SunIntensity *float64    `json:"sunIntensity"`
+	Angle        *float64    `json:"angle"`
+	// Samples is the number of shadow rays for each point lit, it defaults to 16
+	Samples *int `json:"samples"`
+}
+
+// objectFile describes any SceneObject, which fields are needed depends on Type
+type objectFile struct {
+	Type        string      `json:"type"`
+	Material    string      `json:"material"`
+	Position    *[3]float64 `json:"position"`
+	Normal      *[3]float64 `json:"normal"`
+	Axis        *[3]float64 `json:"axis"`
+	Radius      *float64    `json:"radius"`
+	MajorRadius *float64    `json:"majorRadius"`
+	MinorRadius *float64    `json:"minorRadius"`
+	Height      *float64    `json:"height"`
+	Capped      *bool       `json:"capped"`
+	// Vertices are the corners of a triangle
+	Vertices *[3][3]float64 `json:"vertices"`
+	// File is the OBJ file of a mesh, relative to the scene file
+	File string `json:"file"`
+	// Scale multiplies the size of a mesh, a negative scale mirrors it
+	Scale *float64 `json:"scale"`
+}
+
+// lightFile describes any lights.Light, which fields are needed depends on Type
+type lightFile struct {
+	Type     string      `json:"type"`
+	Position *[3]float64 `json:"position"`
+	// Intensity is the irradiance of a directional light
+	Intensity *[3]float64 `json:"intensity"`
+	Size      *float64    `json:"size"`
+	Falloff   *bool       `json:"falloff"`
+	Normal    *[3]float64 `json:"normal"`
+	Direction *[3]float64 `json:"direction"`
+	// Angle is the half angle in degrees of a spot light's cone or a directional light's disc
+	Angle   *float64  `json:"angle"`
+	Blend   *float64  `json:"blend"`
+	Profile []float64 `json:"profile"`
+	// U and V are the sides of a rect light, which shines towards U × V
+	U        *[3]float64 `json:"u"`
+	V        *[3]float64 `json:"v"`
+	Radius   *float64    `json:"radius"`
+	TwoSided *bool       `json:"twoSided"`
+	// Samples is the number of shadow rays for each point lit, it defaults to 16
+	Samples *int `json:"samples"`
+}
+
+// fields reads the values of a decoded section, recording the first problem found along with
+// where in the file it was
+type fields struct {
+	where string
+	err   error
+}
+
+func (f *fields) fail(field, format string, args ...interface{}) {
+	if f.err == nil {
+		f.err = fmt.Errorf("%s: field %q: %s", f.where, field, fmt.Sprintf(format, args...))
+	}
+}
+
+// vec returns the vector v, failing if it is missing and required
+func (f *fields) vec(name string, v *[3]float64, def *core.Vector3) core.Vector3 {
+	if v == nil {
+		if def == nil {
+			f.fail(name, "is required")
+			return core.Vector3{}
+		}
+		return *def
+	}
+
+	return core.Vector3{X: v[0], Y: v[1], Z: v[2]}
+}
+
+// direction returns the vector v, failing if it is missing or zero
+func (f *fields) direction(name string, v *[3]float64) core.Vector3 {
+	d := f.vec(name, v, nil)
+	if v != nil && d.Length() == 0 {
+		f.fail(name, "must not be the zero vector")
+	}
+
+	return d
+}
+
+// number returns n, failing if it is missing and required or is below min
+func (f *fields) number(name string, n *float64, def *float64, min float64) float64 {
+	if n == nil {
+		if def == nil {
+			f.fail(name, "is required")
+			return 0
+		}
+		return *def
+	}
+
+	if *n < min {
+		f.fail(name, "must be at least %v, got %v", min, *n)
+	}
+
+	return *n
+}
+
+// positive returns n, failing if it is missing or not greater than 0
+func (f *fields) positive(name string, n *float64) float64 {
+	if n == nil {
+		f.fail(name, "is required")
+		return 0
+	}
+
+	if *n <= 0 {
+		f.fail(name, "must be positive, got %v", *n)
+	}
+
+	return *n
+}
+
+// onlyUses fails if any field given in set is not one of used, the fields a kind of thing
+// uses, so fields meant for a different type are caught. Fields are checked in name order
+func (f *fields) onlyUses(kind string, used []string, set map[string]bool) {
+	names := make([]string, 0, len(set))
+	for name, isSet := range set {
+		if isSet {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		isUsed := false
+		for _, u := range used {
+			isUsed = isUsed || u == name
+		}
+		if !isUsed {
+			f.fail(name, "is not used by a %s", kind)
+		}
+	}
+}
+
+// decodeStrict decodes raw into v, rejecting fields v does not have
+func decodeStrict(raw json.RawMessage, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// LoadScene reads a JSON scene description from fname and builds a Scene to be rendered at
+// width x height. Errors name the section, object and field that caused them
+func LoadScene(fname string, width, height int) (*Scene, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseScene(data, filepath.Dir(fname), width, height)
+}
+
+// ParseScene builds a Scene from the JSON scene description in data. Files named in the
+// description are found relative to dir
+func ParseScene(data []byte, dir string, width, height int) (*Scene, error) {
+	var file sceneFile
+	if err := decodeStrict(data, &file); err != nil {
+		return nil, fmt.Errorf("scene: %v", err)
+	}
+
+	scene, err := parseCamera(file, width, height)
+	if err != nil {
+		return nil, err
+	}
+	scene.Source = fmt.Sprintf("%x", sha256.Sum256(data))
+
+	textures := make(map[string]mats.Texture, len(file.Textures))
+	for _, name := range sortedNames(file.Textures) {
+		t, err := parseTexture(name, file.Textures[name], dir)
+		if err != nil {
+			return nil, err
+		}
+		textures[name] = t
+	}
+
+	materials := make(map[string]mats.Material, len(file.Materials))
+	for _, name := range sortedNames(file.Materials) {
+		m, err := parseMaterial(name, file.Materials[name], textures)
+		if err != nil {
+			return nil, err
+		}
+		materials[name] = m
+	}
+
+	for i, raw := range file.Objects {
+		objs, err := parseObject(i, raw, dir, materials)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range objs {
+			scene.AddSceneObject(o)
+		}
+	}
+
+	for i, raw := range file.Lights {
+		if err := parseLight(i, raw, scene); err != nil {
+			return nil, err
+		}
+	}
+
+	if file.Environment != nil {
+		if scene.Environment, err = parseEnvironment(file.Environment, dir); err != nil {
+			return nil, err
+		}
+	}
+
+	return scene, nil
+}
+
+// sortedNames returns the names of the sections in a map in order, so the same file always
+// reports the same error
+func sortedNames(sections map[string]json.RawMessage) []string {
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseCamera(file sceneFile, width, height int) (*Scene, error) {
+	if file.Camera == nil {
+		return nil, fmt.Errorf("camera: section is required")
+	}
+
+	var c cameraFile
+	if err := decodeStrict(file.Camera, &c); err != nil {
+		return nil, fmt.Errorf("camera: %v", err)
+	}
+
+	where := "camera"
+	if c.Type != "" {
+		where = fmt.Sprintf("camera (%s)", c.Type)
+	}
+	f := &fields{where: where}
+
+	lens := []string{"apertureRadius", "fStop", "focalLength", "focusDistance", "focusPoint", "blades", "bladeRotation", "catsEye"}
+	uses := map[string][]string{
+		"":             append([]string{"fov"}, lens...),
+		"perspective":  append([]string{"fov"}, lens...),
+		"orthographic": append([]string{"height"}, lens...),
+		"fisheye":      {"fov"},
+		"equirect":     {},
+	}
+	set := map[string]bool{
+		"fov":            c.FOV != nil,
+		"height":         c.Height != nil,
+		"apertureRadius": c.ApertureRadius != nil,
+		"fStop":          c.FStop != nil,
+		"focalLength":    c.FocalLength != nil,
+		"focusDistance":  c.FocusDistance != nil,
+		"focusPoint":     c.FocusPoint != nil,
+		"blades":         c.Blades != nil,
+		"bladeRotation":  c.BladeRotation != nil,
+		"catsEye":        c.CatsEye != nil,
+	}
+	used, ok := uses[c.Type]
+	if !ok {
+		return nil, fmt.Errorf("camera: field \"type\": unknown camera type %q", c.Type)
+	}
+	f.onlyUses(c.Type+" camera", used, set)
+
+	eye := f.vec("eye", c.Eye, &core.Vector3{})
+	target := f.vec("target", c.Target, nil)
+	up := f.direction("up", upOrDefault(c.Up))
+
+	look := target.Subtract(eye)
+	if c.Target != nil && look.Length() == 0 {
+		f.fail("target", "must not be the same as the eye")
+	} else if c.Target != nil && up.Cross(look).Length() < 1e-9*look.Length()*up.Length() {
+		f.fail("up", "must not be parallel to the direction of the target")
+	}
+
+	var camera Camera
+	switch c.Type {
+	case "", "perspective":
+		defaultFOV := 60.0
+		fov := f.number("fov", c.FOV, &defaultFOV, 0)
+		if fov <= 0 || fov >= 180 {
+			f.fail("fov", "must be between 0 and 180, got %v", fov)
+		}
+		camera = NewPerspective(eye, target, up, fov, width, height)
+	case "orthographic":
+		camera = NewOrthographic(eye, target, up, f.positive("height", c.Height), width, height)
+	case "fisheye":
+		defaultFOV := 180.0
+		fov := f.number("fov", c.FOV, &defaultFOV, 0)
+		if fov <= 0 || fov > 360 {
+			f.fail("fov", "must be between 0 and 360, got %v", fov)
+		}
+		camera = NewFisheye(eye, target, up, fov, width, height)
+	case "equirect":
+		camera = NewEquirect(eye, target, up, width, height)
+	}
+
+	var l *Lens
+	if c.ApertureRadius != nil || c.FStop != nil {
+		l = parseLens(f, c, camera.Frame(), look.Length())
+	} else {
+		for _, name := range lens {
+			if set[name] {
+				f.fail(name, "needs \"apertureRadius\" or \"fStop\" to be set")
+			}
+		}
+	}
+
+	fa := &fields{where: "ambient"}
+	ambient := fa.vec("ambient", file.Ambient, &core.Vector3{})
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	if fa.err != nil {
+		return nil, fa.err
+	}
+
+	return NewScene(camera, l, width, height, ambient), nil
+}
+
+// parseLens reads the lens of the camera c with frame fr, which has an aperture set.
+// targetDistance is how far the camera's target is
+func parseLens(f *fields, c cameraFile, fr CameraFrame, targetDistance float64) *Lens {
+	var radius float64
+	switch {
+	case c.ApertureRadius != nil && c.FStop != nil:
+		f.fail("fStop", "can't be used with \"apertureRadius\"")
+	case c.ApertureRadius != nil:
+		radius = f.positive("apertureRadius", c.ApertureRadius)
+		if c.FocalLength != nil {
+			f.fail("focalLength", "is only used with \"fStop\"")
+		}
+	default:
+		radius = FStopRadius(f.positive("focalLength", c.FocalLength), f.positive("fStop", c.FStop))
+	}
+
+	focusDistance := targetDistance
+	switch {
+	case c.FocusDistance != nil && c.FocusPoint != nil:
+		f.fail("focusPoint", "can't be used with \"focusDistance\"")
+	case c.FocusDistance != nil:
+		focusDistance = f.positive("focusDistance", c.FocusDistance)
+	case c.FocusPoint != nil:
+		if focusDistance = FocusOn(fr, f.vec("focusPoint", c.FocusPoint, nil)); focusDistance <= 0 {
+			f.fail("focusPoint", "must be in front of the camera")
+		}
+	}
+
+	lens := NewLens(radius, focusDistance)
+	if c.Blades != nil {
+		if lens.Blades = *c.Blades; lens.Blades != 0 && lens.Blades < 3 {
+			f.fail("blades", "must be 0 for a round aperture or at least 3, got %d", lens.Blades)
+		}
+	}
+
+	zero := 0.0
+	lens.BladeRotation = f.number("bladeRotation", c.BladeRotation, &zero, math.Inf(-1)) * math.Pi / 180
+	if lens.CatsEye = f.number("catsEye", c.CatsEye, &zero, 0); lens.CatsEye > 1 {
+		f.fail("catsEye", "must be at most 1, got %v", lens.CatsEye)
+	}
+
+	return lens
+}
+
+// upOrDefault returns up, or +z if it is missing
+func upOrDefault(up *[3]float64) *[3]float64 {
+	if up == nil {
+		return &[3]float64{0, 0, 1}
+	}
+	return up
+}
+
+func parseTexture(name string, raw json.RawMessage, dir string) (mats.Texture, error) {
+	where := fmt.Sprintf("texture %q", name)
+
+	var t textureFile
+	if err := decodeStrict(raw, &t); err != nil {
+		return nil, fmt.Errorf("%s: %v", where, err)
+	}
+
+	where = fmt.Sprintf("texture %q (%s)", name, t.Type)
+	f := &fields{where: where}
+
+	uses := map[string][]string{
+		"constant": {"color"},
+		"image":    {"file", "wrap", "srgb", "scale"},
+		"checker":  {"colors", "scale"},
+		"noise":    {"color", "scale", "seed"},
+		"marble":   {"colors", "scale", "turbulence", "seed"},
+	}
+	set := map[string]bool{
+		"file":       t.File != "",
+		"wrap":       t.Wrap != "",
+		"srgb":       t.SRGB != nil,
+		"scale":      t.Scale != nil,
+		"color":      t.Color != nil,
+		"colors":     t.Colors != nil,
+		"turbulence": t.Turbulence != nil,
+		"seed":       t.Seed != nil,
+	}
+	used, ok := uses[t.Type]
+	if !ok {
+		return nil, fmt.Errorf("%s: field \"type\": unknown texture type %q", where, t.Type)
+	}
+	f.onlyUses(t.Type+" texture", used, set)
+
+	one, zero := 1.0, 0.0
+	colors := func() (core.Vector3, core.Vector3) {
+		if t.Colors == nil {
+			f.fail("colors", "is required")
+			return core.Vector3{}, core.Vector3{}
+		}
+		a, b := t.Colors[0], t.Colors[1]
+		return core.Vector3{X: a[0], Y: a[1], Z: a[2]}, core.Vector3{X: b[0], Y: b[1], Z: b[2]}
+	}
+	seed := int64(1)
+	if t.Seed != nil {
+		seed = *t.Seed
+	}
+
+	var tex mats.Texture
+	switch t.Type {
+	case "constant":
+		tex = mats.Constant{Color: f.vec("color", t.Color, nil)}
+	case "image":
+		wrap := mats.WrapRepeat
+		if t.Wrap != "" {
+			var err error
+			if wrap, err = mats.ParseWrapMode(t.Wrap); err != nil {
+				f.fail("wrap", "%v", err)
+			}
+		}
+		if t.File == "" {
+			f.fail("file", "is required")
+		}
+		scale := f.number("scale", t.Scale, &one, 0)
+		if f.err != nil {
+			return nil, f.err
+		}
+
+		fname := t.File
+		if !filepath.IsAbs(fname) {
+			fname = filepath.Join(dir, fname)
+		}
+
+		img, err := mats.LoadImageTexture(fname, wrap, t.SRGB == nil || *t.SRGB)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", where, err)
+		}
+		img.Scale = scale
+		tex = img
+	case "checker":
+		even, odd := colors()
+		tex = mats.Checker{Even: mats.Constant{Color: even}, Odd: mats.Constant{Color: odd}, Scale: f.positive("scale", t.Scale)}
+	case "noise":
+		tex = mats.Noise{Perlin: mats.NewPerlin(seed), Color: f.vec("color", t.Color, &core.Vector3{X: 1, Y: 1, Z: 1}), Scale: f.positive("scale", t.Scale)}
+	case "marble":
+		a, b := colors()
+		tex = mats.Marble{
+			Perlin:     mats.NewPerlin(seed),
+			A:          a,
+			B:          b,
+			Scale:      f.positive("scale", t.Scale),
+			Turbulence: f.number("turbulence", t.Turbulence, &zero, 0),
+		}
+	}
+
+	return tex, f.err
+}
+
+func parseMaterial(name string, raw json.RawMessage, textures map[string]mats.Texture) (mats.Material, error) {
+	where := fmt.Sprintf("material %q", name)
+
+	var m materialFile
+	if err := decodeStrict(raw, &m); err != nil {
+		return mats.Material{}, fmt.Errorf("%s: %v", where, err)
+	}
+
+	if m.Type != "" && m.Type != "phong" {
+		where = fmt.Sprintf("material %q (%s)", name, m.Type)
+	}
+	f := &fields{where: where}
+
+	uses := map[string][]string{
+		"":           {"ka", "kd", "ks", "roughness", "reflectivity", "ior", "transmission", "absorption", "kdMap", "ksMap", "reflectivityMap", "roughnessMap", "normalMap", "bumpMap", "bumpScale"},
+		"phong":      {"ka", "kd", "ks", "roughness", "reflectivity", "ior", "transmission", "absorption", "kdMap", "ksMap", "reflectivityMap", "roughnessMap", "normalMap", "bumpMap", "bumpScale"},
+		"lambertian": {"ka", "color", "kdMap", "normalMap", "bumpMap", "bumpScale"},
+		"conductor":  {"ka", "color", "roughness", "kdMap", "roughnessMap", "normalMap", "bumpMap", "bumpScale"},
+		"dielectric": {"ka", "color", "roughness", "ior", "absorption", "kdMap", "roughnessMap", "normalMap", "bumpMap", "bumpScale"},
+		"principled": {"ka", "color", "metallic", "roughness", "ior", "transmission", "absorption", "kdMap", "roughnessMap", "normalMap", "bumpMap", "bumpScale"},
+	}
+	set := map[string]bool{
+		"ka":           m.Ka != nil,
+		"kd":           m.Kd != nil,
+		"ks":           m.Ks != nil,
+		"roughness":    m.Roughness != nil,
+		"reflectivity": m.Reflectivity != nil,
+		"ior":          m.IOR != nil,
+		"transmission": m.Transmission != nil,
+		"absorption":   m.Absorption != nil,
+		"color":        m.Color != nil,
+		"metallic":     m.Metallic != nil,
+
+		"kdMap":           m.KdMap != "",
+		"ksMap":           m.KsMap != "",
+		"reflectivityMap": m.ReflectivityMap != "",
+		"roughnessMap":    m.RoughnessMap != "",
+		"normalMap":       m.NormalMap != "",
+		"bumpMap":         m.BumpMap != "",
+		"bumpScale":       m.BumpScale != nil,
+	}
+	used, ok := uses[m.Type]
+	if !ok {
+		return mats.Material{}, fmt.Errorf("%s: field \"type\": unknown material type %q", where, m.Type)
+	}
+	f.onlyUses(m.Type+" material", used, set)
+
+	// texture returns the texture called ref, or nil if there is none
+	texture := func(field, ref string) mats.Texture {
+		if ref == "" {
+			return nil
+		}
+		t, ok := textures[ref]
+		if !ok {
+			f.fail(field, "unknown texture %q", ref)
+		}
+		return t
+	}
+
+	// A textured colour is just the texture unless it is given
+	var colour *core.Vector3
+	if m.KdMap != "" {
+		colour = &core.Vector3{X: 1, Y: 1, Z: 1}
+	}
+
+	var material mats.Material
+	if m.Type != "" && m.Type != "phong" {
+		material = parseBSDFMaterial(f, m, colour)
+	} else {
+		material = parsePhongMaterial(f, m, colour)
+	}
+
+	material.KdMap = texture("kdMap", m.KdMap)
+	material.KsMap = texture("ksMap", m.KsMap)
+	material.ReflectivityMap = texture("reflectivityMap", m.ReflectivityMap)
+	material.RoughnessMap = texture("roughnessMap", m.RoughnessMap)
+	material.NormalMap = texture("normalMap", m.NormalMap)
+	material.BumpMap = texture("bumpMap", m.BumpMap)
+
+	material.BumpScale = 1
+	if m.BumpScale != nil {
+		material.BumpScale = *m.BumpScale
+	}
+	if m.NormalMap != "" && m.BumpMap != "" {
+		f.fail("bumpMap", "can't be used with a normalMap")
+	}
+
+	material.Emission = f.vec("emission", m.Emission, &core.Vector3{})
+	if e := material.Emission; e.X < 0 || e.Y < 0 || e.Z < 0 {
+		f.fail("emission", "must not be negative, got %v", e)
+	}
+
+	material.EmissionSamples = 16
+	if m.EmissionSamples != nil {
+		material.EmissionSamples = *m.EmissionSamples
+		if m.Emission == nil {
+			f.fail("emissionSamples", "is not used without an emission")
+		}
+		if material.EmissionSamples < 1 {
+			f.fail("emissionSamples", "must be at least 1, got %d", material.EmissionSamples)
+		}
+	}
+
+	return material, f.err
+}
+
+// parsePhongMaterial builds a material from the Phong parameters, using colour for a missing kd
+func parsePhongMaterial(f *fields, m materialFile, colour *core.Vector3) mats.Material {
+	zero, one := 0.0, 1.0
+	material := mats.Material{
+		Ka:           f.vec("ka", m.Ka, &core.Vector3{}),
+		Kd:           f.vec("kd", m.Kd, colour),
+		Ks:           f.vec("ks", m.Ks, &core.Vector3{}),
+		Roughness:    f.number("roughness", m.Roughness, &zero, 0),
+		Reflectivity: f.number("reflectivity", m.Reflectivity, &zero, 0),
+		IOR:          f.number("ior", m.IOR, &one, 1),
+		Transmission: f.number("transmission", m.Transmission, &zero, 0),
+		Absorption:   f.vec("absorption", m.Absorption, &core.Vector3{}),
+	}
+
+	if material.Reflectivity > 1 {
+		f.fail("reflectivity", "must be at most 1, got %v", material.Reflectivity)
+	}
+	if material.Transmission > 1 {
+		f.fail("transmission", "must be at most 1, got %v", material.Transmission)
+	}
+	if a := material.Absorption; a.X < 0 || a.Y < 0 || a.Z < 0 {
+		f.fail("absorption", "must not be negative")
+	}
+
+	return material
+}
+
+// parseBSDFMaterial builds a material with one of the BSDFs in mats, using colour for a missing
+// color. m.Type must be known
+func parseBSDFMaterial(f *fields, m materialFile, colour *core.Vector3) mats.Material {
+	zero, one, glass := 0.0, 1.0, 1.5
+
+	// fraction reads a number that must be in [0, 1]
+	fraction := func(name string, n *float64) float64 {
+		v := f.number(name, n, &zero, 0)
+		if v > 1 {
+			f.fail(name, "must be at most 1, got %v", v)
+		}
+		return v
+	}
+
+	material := mats.Material{
+		Ka:         f.vec("ka", m.Ka, &core.Vector3{}),
+		Absorption: f.vec("absorption", m.Absorption, &core.Vector3{}),
+	}
+	if a := material.Absorption; a.X < 0 || a.Y < 0 || a.Z < 0 {
+		f.fail("absorption", "must not be negative")
+	}
+
+	switch m.Type {
+	case "lambertian":
+		material.BSDF = mats.Lambertian{Albedo: f.vec("color", m.Color, colour)}
+	case "conductor":
+		material.BSDF = mats.Conductor{F0: f.vec("color", m.Color, colour), Roughness: fraction("roughness", m.Roughness)}
+	case "dielectric":
+		material.BSDF = mats.Dielectric{
+			IOR:       f.number("ior", m.IOR, &glass, 1),
+			Roughness: fraction("roughness", m.Roughness),
+			Tint:      f.vec("color", m.Color, &core.Vector3{X: 1, Y: 1, Z: 1}),
+		}
+	case "principled":
+		material.BSDF = mats.Principled{
+			BaseColor:    f.vec("color", m.Color, colour),
+			Metallic:     fraction("metallic", m.Metallic),
+			Roughness:    f.number("roughness", m.Roughness, &one, 0),
+			Transmission: fraction("transmission", m.Transmission),
+			IOR:          f.number("ior", m.IOR, &glass, 1),
+		}
+		if p := material.BSDF.(mats.Principled); p.Roughness > 1 {
+			f.fail("roughness", "must be at most 1, got %v", p.Roughness)
+		}
+	}
+
+	return material
+}
+
+// parseObject builds the objects described by raw, this is a single object except for meshes
+// which give one object per material
+func parseObject(i int, raw json.RawMessage, dir string, materials map[string]mats.Material) ([]sobjs.SceneObject, error) {
+	where := fmt.Sprintf("object %d", i)
+
+	var o objectFile
+	if err := decodeStrict(raw, &o); err != nil {
+		return nil, fmt.Errorf("%s: %v", where, err)
+	}
+
+	where = fmt.Sprintf("object %d (%s)", i, o.Type)
+	f := &fields{where: where}
+
+	// Meshes can take their materials from the OBJ file instead
+	material, ok := materials[o.Material]
+	if !ok {
+		if o.Material != "" {
+			f.fail("material", "unknown material %q", o.Material)
+		} else if o.Type != "mesh" {
+			f.fail("material", "is required")
+		}
+	}
+
+	uses := map[string][]string{
+		"sphere":   {"position", "radius"},
+		"plane":    {"position", "normal"},
+		"disk":     {"position", "normal", "radius"},
+		"torus":    {"position", "axis", "majorRadius", "minorRadius"},
+		"cylinder": {"position", "axis", "height", "radius", "capped"},
+		"cone":     {"position", "axis", "height", "radius", "capped"},
+		"triangle": {"vertices"},
+		"mesh":     {"position", "file", "scale"},
+	}
+	set := map[string]bool{
+		"position":    o.Position != nil,
+		"normal":      o.Normal != nil,
+		"axis":        o.Axis != nil,
+		"radius":      o.Radius != nil,
+		"majorRadius": o.MajorRadius != nil,
+		"minorRadius": o.MinorRadius != nil,
+		"height":      o.Height != nil,
+		"capped":      o.Capped != nil,
+		"vertices":    o.Vertices != nil,
+		"file":        o.File != "",
+		"scale":       o.Scale != nil,
+	}
+	used, ok := uses[o.Type]
+	switch {
+	case o.Type == "":
+		return nil, fmt.Errorf("%s: field %q: is required", where, "type")
+	case !ok:
+		return nil, fmt.Errorf("%s: field %q: unknown object type %q", where, "type", o.Type)
+	}
+	f.onlyUses(o.Type, used, set)
+
+	var objs []sobjs.SceneObject
+	switch o.Type {
+	case "sphere":
+		objs = append(objs, sobjs.NewSphere(f.vec("position", o.Position, nil), f.positive("radius", o.Radius), material))
+	case "plane":
+		objs = append(objs, sobjs.NewPlane(f.vec("position", o.Position, nil), f.direction("normal", o.Normal), material))
+	case "disk":
+		objs = append(objs, sobjs.NewDisk(f.vec("position", o.Position, nil), f.direction("normal", o.Normal), f.positive("radius", o.Radius), material))
+	case "torus":
+		axis := f.vec("axis", o.Axis, &core.Vector3{X: 0, Y: 0, Z: 1})
+		if axis.Length() == 0 {
+			f.fail("axis", "must not be the zero vector")
+		}
+		objs = append(objs, sobjs.NewTorus(f.vec("position", o.Position, nil), axis, f.positive("majorRadius", o.MajorRadius), f.positive("minorRadius", o.MinorRadius), material))
+	case "cylinder", "cone":
+		axis := f.direction("axis", o.Axis)
+		pos := f.vec("position", o.Position, nil)
+		height, radius := f.positive("height", o.Height), f.positive("radius", o.Radius)
+
+		capped := true
+		if o.Capped != nil {
+			capped = *o.Capped
+		}
+
+		if o.Type == "cylinder" {
+			objs = append(objs, sobjs.NewCylinder(pos, axis, height, radius, capped, material))
+		} else {
+			objs = append(objs, sobjs.NewCone(pos, axis, height, radius, capped, material))
+		}
+	case "triangle":
+		if o.Vertices == nil {
+			f.fail("vertices", "is required")
+			break
+		}
+
+		v := o.Vertices
+		v0, v1, v2 := f.vec("vertices", &v[0], nil), f.vec("vertices", &v[1], nil), f.vec("vertices", &v[2], nil)
+		if v1.Subtract(v0).Cross(v2.Subtract(v0)).Length() == 0 {
+			f.fail("vertices", "must not be in a line")
+		}
+		objs = append(objs, sobjs.NewTriangle(v0, v1, v2, material))
+	case "mesh":
+		one := 1.0
+		offset := f.vec("position", o.Position, &core.Vector3{})
+		scale := f.number("scale", o.Scale, &one, math.Inf(-1))
+		if scale == 0 {
+			f.fail("scale", "must not be 0")
+		}
+		if o.File == "" {
+			f.fail("file", "is required")
+		}
+		if f.err != nil {
+			return nil, f.err
+		}
+
+		fname := o.File
+		if !filepath.IsAbs(fname) {
+			fname = filepath.Join(dir, fname)
+		}
+
+		meshes, err := sobjs.LoadOBJ(fname, material)
+		if err != nil {
+			return nil, fmt.Errorf("%s: field %q: %v", where, "file", err)
+		}
+
+		for _, m := range meshes {
+			// A material named in the scene replaces the ones from the OBJ file
+			if o.Material != "" {
+				m.Mat = material
+			}
+			m.Transform(scale, offset)
+			objs = append(objs, m)
+		}
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	return objs, nil
+}
+
+// parseLight adds the light described by raw to scene
+func parseLight(i int, raw json.RawMessage, scene *Scene) error {
+	where := fmt.Sprintf("light %d", i)
+
+	var l lightFile
+	if err := decodeStrict(raw, &l); err != nil {
+		return fmt.Errorf("%s: %v", where, err)
+	}
+
+	f := &fields{where: where}
+
+	uses := map[string][]string{
+		"":            {"position", "intensity", "size", "falloff"},
+		"point":       {"position", "intensity", "size", "falloff"},
+		"directional": {"direction", "intensity", "angle", "samples"},
+		"spot":        {"position", "direction", "intensity", "angle", "blend", "falloff"},
+		"profile":     {"position", "direction", "intensity", "profile", "falloff"},
+		"rect":        {"position", "intensity", "u", "v", "twoSided", "samples"},
+		"disk":        {"position", "intensity", "normal", "radius", "twoSided", "samples"},
+		"sphere":      {"position", "intensity", "radius", "samples"},
+	}
+	set := map[string]bool{
+		"position":  l.Position != nil,
+		"intensity": l.Intensity != nil,
+		"size":      l.Size != nil,
+		"falloff":   l.Falloff != nil,
+		"normal":    l.Normal != nil,
+		"direction": l.Direction != nil,
+		"angle":     l.Angle != nil,
+		"blend":     l.Blend != nil,
+		"profile":   l.Profile != nil,
+		"u":         l.U != nil,
+		"v":         l.V != nil,
+		"radius":    l.Radius != nil,
+		"twoSided":  l.TwoSided != nil,
+		"samples":   l.Samples != nil,
+	}
+	used, ok := uses[l.Type]
+	if !ok {
+		return fmt.Errorf("%s: field \"type\": unknown light type %q", where, l.Type)
+	}
+	f.onlyUses(l.Type+" light", used, set)
+
+	intensity := f.vec("intensity", l.Intensity, nil)
+
+	samples := 16
+	if l.Samples != nil {
+		samples = *l.Samples
+		if samples < 1 {
+			f.fail("samples", "must be at least 1, got %d", samples)
+		}
+	}
+	twoSided := l.TwoSided != nil && *l.TwoSided
+	falloff := l.Falloff != nil && *l.Falloff
+
+	var light lights.Light
+	switch l.Type {
+	case "", "point":
+		zero := 0.0
+		position := f.vec("position", l.Position, nil)
+		light = &lights.Point{Position: position, Intensity: intensity, Size: f.number("size", l.Size, &zero, 0), Falloff: falloff}
+	case "directional":
+		zero := 0.0
+		direction := f.direction("direction", l.Direction)
+		angle := f.number("angle", l.Angle, &zero, 0)
+		if angle >= 90 {
+			f.fail("angle", "must be less than 90, got %v", angle)
+		}
+		light = lights.NewDirectional(direction, intensity, angle*math.Pi/180, samples)
+	case "spot":
+		zero := 0.0
+		position, direction := f.vec("position", l.Position, nil), f.direction("direction", l.Direction)
+		angle := f.positive("angle", l.Angle)
+		if angle > 180 {
+			f.fail("angle", "must be at most 180, got %v", angle)
+		}
+		blend := f.number("blend", l.Blend, &zero, 0)
+		if blend > 1 {
+			f.fail("blend", "must be at most 1, got %v", blend)
+		}
+		light = lights.NewSpot(position, direction, intensity, angle*math.Pi/180, blend, falloff)
+	case "profile":
+		position, direction := f.vec("position", l.Position, nil), f.direction("direction", l.Direction)
+		if len(l.Profile) == 0 {
+			f.fail("profile", "is required")
+		}
+		for _, v := range l.Profile {
+			if v < 0 {
+				f.fail("profile", "must not be negative, got %v", v)
+			}
+		}
+		light = lights.NewProfile(position, direction, intensity, l.Profile, falloff)
+	case "rect":
+		position := f.vec("position", l.Position, nil)
+		u, v := f.direction("u", l.U), f.direction("v", l.V)
+		if f.err == nil && u.Cross(v).Length() == 0 {
+			f.fail("v", "must not be parallel to u")
+		}
+		light = lights.NewRect(position, u, v, intensity, twoSided, samples)
+	case "disk":
+		position := f.vec("position", l.Position, nil)
+		normal, radius := f.direction("normal", l.Normal), f.positive("radius", l.Radius)
+		light = lights.NewDisk(position, normal, radius, intensity, twoSided, samples)
+	case "sphere":
+		position := f.vec("position", l.Position, nil)
+		light = lights.NewSphere(position, f.positive("radius", l.Radius), intensity, samples)
+	}
+
+	if f.err != nil {
+		return f.err
+	}
+	scene.AddSceneLight(light)
+	return nil
+}
+
+func parseEnvironment(raw json.RawMessage, dir string) (*lights.Environment, error) {
+	where := "environment"
+
+	var e environmentFile
+	if err := decodeStrict(raw, &e); err != nil {
+		return nil, fmt.Errorf("%s: %v", where, err)
+	}
+
+	f := &fields{where: where}
+
+	uses := map[string][]string{
+		"image":    {"file", "scale", "rotation", "samples"},
+		"gradient": {"zenith", "horizon", "ground", "samples"},
+		"sunsky":   {"sun", "turbidity", "scale", "sunIntensity", "angle", "ground", "samples"},
+	}
+	set := map[string]bool{
+		"file":         e.File != "",
+		"scale":        e.Scale != nil,
+		"rotation":     e.Rotation != nil,
+		"zenith":       e.Zenith != nil,
+		"horizon":      e.Horizon != nil,
+		"ground":       e.Ground != nil,
+		"sun":          e.Sun != nil,
+		"turbidity":    e.Turbidity != nil,
+		"sunIntensity": e.SunIntensity != nil,
+		"angle":        e.Angle != nil,
+		"samples":      e.Samples != nil,
+	}
+	used, ok := uses[e.Type]
+	if !ok {
+		return nil, fmt.Errorf("%s: field \"type\": unknown environment type %q", where, e.Type)
+	}
+	f.onlyUses(e.Type+" environment", used, set)
+
+	samples := 16
+	if e.Samples != nil {
+		samples = *e.Samples
+		if samples < 1 {
+			f.fail("samples", "must be at least 1, got %d", samples)
+		}
+	}
+
+	var background lights.Background
+	switch e.Type {
+	case "image":
+		one, zero := 1.0, 0.0
+		scale := f.number("scale", e.Scale, &one, 0)
+		rotation := f.number("rotation", e.Rotation, &zero, -360)
+		if e.File == "" {
+			f.fail("file", "is required")
+		}
+		if f.err != nil {
+			return nil, f.err
+		}
+
+		fname := e.File
+		if !filepath.IsAbs(fname) {
+			fname = filepath.Join(dir, fname)
+		}
+
+		img, err := lights.LoadEquirect(fname, scale, rotation*math.Pi/180)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", where, err)
+		}
+		background = img
+	case "gradient":
+		background = &lights.Gradient{
+			Zenith:  f.vec("zenith", e.Zenith, nil),
+			Horizon: f.vec("horizon", e.Horizon, nil),
+			Ground:  f.vec("ground", e.Ground, &core.Vector3{}),
+		}
+	case "sunsky":
+		turbidity, scale, intensity, angle := 3.0, 0.05, 3.0, 0.27
+		sun := f.direction("sun", e.Sun)
+		turbidity = f.number("turbidity", e.Turbidity, &turbidity, 1.7)
+		if turbidity > 10 {
+			f.fail("turbidity", "must be at most 10, got %v", turbidity)
+		}
+		scale = f.number("scale", e.Scale, &scale, 0)
+		intensity = f.number("sunIntensity", e.SunIntensity, &intensity, 0)
+		angle = f.number("angle", e.Angle, &angle, 0)
+		if angle >= 90 {
+			f.fail("angle", "must be less than 90, got %v", angle)
+		}
+		ground := f.vec("ground", e.Ground, &core.Vector3{X: 0.3, Y: 0.3, Z: 0.3})
+		if f.err != nil {
+			return nil, f.err
+		}
+
+		background = lights.NewSunSky(sun, turbidity, scale, intensity, angle*math.Pi/180, ground)
+	}
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	return lights.NewEnvironment(background, samples), nil
+}
@@ -0,0 +1,81 @@
+package tracer
+
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/mats"
+	"github.com/benvardy/raytracing/sobjs"
+)
+
+// reflect mirrors the direction d about the normal n
+func reflect(d, n vector3) vector3 {
+	return d.Subtract(n.Smult(2 * d.Dot(n)))
+}
+
+// refract bends the unit direction d through a surface with unit normal n facing against d,
+// where eta is the ratio of the indices of refraction n1 / n2. It returns false on total
+// internal reflection
+func refract(d, n vector3, eta float64) (vector3, bool) {
+	cosI := -d.Dot(n)
+	k := 1 - eta*eta*(1-cosI*cosI)
+	if k < 0 {
+		return vector3{}, false
+	}
+
+	return d.Smult(eta).Add(n.Smult(eta*cosI - math.Sqrt(k))).Normalize(), true
+}
+
+// schlick approximates the Fresnel reflectance going from index n1 to n2, where cosI is the
+// cosine of the angle between the ray and the normal. Past the critical angle it returns 1
+func schlick(cosI, n1, n2 float64) float64 {
+	r0 := (n1 - n2) / (n1 + n2)
+	r0 *= r0
+
+	// Going into a less dense medium the angle of the transmitted ray has to be used
+	if n1 > n2 {
+		eta := n1 / n2
+		sin2T := eta * eta * (1 - cosI*cosI)
+		if sin2T > 1 {
+			return 1
+		}
+		cosI = math.Sqrt(1 - sin2T)
+	}
+
+	x := 1 - cosI
+	return r0 + (1-r0)*x*x*x*x*x
+}
+
+// beerLambert returns the fraction of each colour left after travelling dist through a medium
+// with the given absorption
+func beerLambert(absorption vector3, dist float64) vector3 {
+	return vector3{
+		math.Exp(-absorption.X * dist),
+		math.Exp(-absorption.Y * dist),
+		math.Exp(-absorption.Z * dist),
+	}
+}
+
+// dielectricNormal returns the normal facing against the ray d at a transparent surface,
+// with the indices of refraction on the side the ray comes from n1 and goes into n2
+func dielectricNormal(hit *sobjs.Hit, d vector3, material mats.Material) (vector3, float64, float64) {
+	ior := material.IOR
+	if ior == 0 {
+		ior = 1
+	}
+
+	n := hit.ShadingNormal
+	n1, n2 := 1.0, ior
+	if !hit.FrontFace {
+		n = n.Smult(-1)
+		n1, n2 = ior, 1.0
+	}
+	// A smooth shading normal can face away from the ray even when the surface doesn't
+	if d.Dot(n) > 0 {
+		n = hit.Normal
+		if !hit.FrontFace {
+			n = n.Smult(-1)
+		}
+	}
+
+	return n, n1, n2
+}
@@ -0,0 +1,95 @@
+package tracer
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// RenderMode selects how Trace works out the light along each ray
+type RenderMode int
+
+const (
+	// ModeWhitted is the recursive ray tracer with Phong lighting and mirror reflections
+	ModeWhitted RenderMode = iota
+	// ModePath is an unbiased Monte Carlo path tracer for global illumination. Point, spot and
+	// profile lights fall off with the square of the distance whether or not Falloff is set, a
+	// light with a Size is a glowing sphere of that diameter, and ambient light is not used
+	ModePath
+)
+
+// ParseRenderMode returns the mode with the name given by String
+func ParseRenderMode(name string) (RenderMode, error) {
+	for _, m := range []RenderMode{ModeWhitted, ModePath} {
+		if m.String() == name {
+			return m, nil
+		}
+	}
+
+	return ModeWhitted, fmt.Errorf("unknown render mode %q", name)
+}
+
+func (m RenderMode) String() string {
+	switch m {
+	case ModeWhitted:
+		return "whitted"
+	case ModePath:
+		return "path"
+	}
+	return fmt.Sprintf("RenderMode(%d)", int(m))
+}
+
+// RenderOptions holds the settings Trace renders a scene with
+type RenderOptions struct {
+	// PixelSamples is the number of points in each pixel the scene is seen through, placed by
+	// Pattern and weighed together by Filter
+	PixelSamples int
+	Pattern      SamplePattern
+	Filter       PixelFilter
+	// Passes is the number of times PixelSamples are taken in every pixel. TraceProgressive
+	// hands back the image after each one
+	Passes int
+	// AdaptiveThreshold turns on adaptive sampling if it is positive. Passes then go on taking
+	// PixelSamples more in each pixel until the standard error of their mean luminance is below
+	// AdaptiveThreshold times that luminance, or they have MaxPixelSamples
+	AdaptiveThreshold float64
+	MaxPixelSamples   int
+
+	// DOF turns on depth of field, seeing each pixel from LensSamples points across the lens
+	DOF         bool
+	LensSamples int
+	// Shading turns on distributed soft shadows
+	Shading bool
+
+	// Mode is the integrator to render with
+	Mode RenderMode
+	// Samples is the number of paths traced for each camera ray in ModePath
+	Samples int
+
+	// Workers is the number of goroutines rendering tiles
+	Workers int
+	// Seed seeds the random sampling, renders with the same seed are identical
+	Seed int64
+
+	// MaxDepth is the most bounces a ray can make before it is cut off
+	MaxDepth int
+	// RussianRoulette randomly ends rays after RouletteDepth bounces with a chance based on how
+	// much they can still add to the image, weighting the survivors to keep the result unbiased.
+	// MaxDepth then only acts as a safety limit and can be set much higher
+	RussianRoulette bool
+	RouletteDepth   int
+}
+
+// DefaultRenderOptions returns the options used when none are given
+func DefaultRenderOptions() RenderOptions {
+	return RenderOptions{
+		Workers:         runtime.NumCPU(),
+		Seed:            1,
+		PixelSamples:    1,
+		Passes:          1,
+		MaxPixelSamples: 64,
+		Samples:         16,
+		LensSamples:     25,
+		MaxDepth:        3,
+		RouletteDepth:   2,
+	}
+}
@@ -0,0 +1,164 @@
+package tracer
+
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/lights"
+	"github.com/benvardy/raytracing/mats"
+)
+
+// pathTrace returns an estimate of the radiance arriving at s from the direction of the unit
+// vector -d, following a single random path through the scene. At every diffuse or glossy
+// bounce the lights are sampled directly and combined with the lights the path hits by itself
+// using multiple importance sampling
+func (w *worker) pathTrace(s, d vector3) vector3 {
+	scene := w.scene
+	radiance := vector3{}
+	throughput := white
+
+	// bsdfPdf is the density the last bounce chose d with. It is 0 for camera rays, mirrors
+	// and refraction, as lights were not sampled there, so lights hit next count in full
+	bsdfPdf := 0.0
+
+	for depth := 0; depth < w.opts.MaxDepth; depth++ {
+		hit := scene.bvh.ClosestHit(s, d, rayEpsilon, math.Inf(1))
+		tMax := math.Inf(1)
+		if hit != nil {
+			tMax = hit.T
+		}
+
+		// Lights are not objects so are checked separately. They don't reflect, so the path ends
+		if light, emitted, ok := w.hitLight(s, d, tMax); ok {
+			weight := 1.0
+			if bsdfPdf > 0 {
+				n := float64(light.SampleCount())
+				weight = powerHeuristic(bsdfPdf, n*light.Pdf(s, d))
+			}
+			radiance = radiance.Add(throughput.Mult(emitted).Smult(weight))
+			break
+		}
+
+		if hit == nil {
+			break
+		}
+
+		material := surfaceMaterial(hit)
+		hit.ApplyNormalMap(material)
+
+		// Glowing objects are hit like any other, and are weighed against sampling them if they
+		// can be
+		if hit.FrontFace && material.Emissive() {
+			weight := 1.0
+			if light, ok := w.lit.byObject[hit.Object]; ok && bsdfPdf > 0 {
+				weight = powerHeuristic(bsdfPdf, float64(light.SampleCount())*light.pdfAt(hit, d))
+			}
+			radiance = radiance.Add(throughput.Mult(material.Emission).Smult(weight))
+		}
+
+		// Light travelling inside a transparent object is absorbed on the way
+		if !hit.FrontFace && material.Transmittance() > 0 {
+			throughput = throughput.Mult(beerLambert(material.Absorption, hit.T))
+		}
+
+		bsdf := material.GetBSDF()
+		f := shadingFrame(hit, d.Smult(-1))
+		wo := f.toLocal(d.Smult(-1))
+
+		radiance = radiance.Add(throughput.Mult(w.sampleLights(hit.Point, wo, f, bsdf)))
+
+		sample, ok := bsdf.Sample(wo, w.rng.Float64(), w.rng.Float64(), w.rng.Float64())
+		if !ok || sample.Pdf <= 0 {
+			break
+		}
+
+		throughput = throughput.Mult(sample.F.Smult(math.Abs(sample.Wi.Z) / sample.Pdf))
+		d = f.toWorld(sample.Wi).Normalize()
+
+		// Lights can't be sampled for smooth surfaces, so lights hit next count in full
+		bsdfPdf = sample.Pdf
+		if sample.Specular {
+			bsdfPdf = 0
+		}
+		s = hit.Point
+
+		if w.opts.RussianRoulette && depth >= w.opts.RouletteDepth {
+			survival := math.Min(1, math.Max(throughput.X, math.Max(throughput.Y, throughput.Z)))
+			if w.rng.Float64() >= survival {
+				break
+			}
+			throughput = throughput.Smult(1 / survival)
+		}
+	}
+
+	return radiance
+}
+
+// hitLight returns the nearest light the ray s + λd hits before tMax and the radiance it sends
+// back along the ray. The environment is infinitely far away so is only hit if tMax is infinite
+func (w *worker) hitLight(s, d vector3, tMax float64) (lights.AreaLight, vector3, bool) {
+	var closest lights.AreaLight
+	var emitted vector3
+	for _, light := range w.lit.emitters {
+		if t, radiance, ok := light.Intersect(s, d); ok && t <= tMax {
+			closest, emitted, tMax = light, radiance, t
+		}
+	}
+
+	return closest, emitted, closest != nil
+}
+
+// sampleLights estimates the light arriving directly from every light at p and leaving towards
+// wo, for the parts of bsdf that aren't perfectly smooth. wo is in the local frame f. Lights
+// with a shape take their own number of stratified samples, which are weighted against the
+// single BSDF sample that could have hit them
+func (w *worker) sampleLights(p, wo vector3, f frame, bsdf mats.BSDF) vector3 {
+	c := vector3{}
+
+	// Lights paths can't hit can only be reached by sampling them
+	for _, light := range w.lit.direct {
+		n := light.SampleCount()
+		for i := 0; i < n; i++ {
+			sample, ok := w.sampleLight(p, light, i, n)
+			if !ok {
+				continue
+			}
+
+			wiLocal := f.toLocal(sample.Wi)
+			fr := bsdf.Eval(wo, wiLocal)
+			if fr == (vector3{}) {
+				continue
+			}
+
+			visible := w.lightShadow(p, sample)
+			c = c.Add(fr.Mult(sample.Radiance).Mult(visible).Smult(math.Abs(wiLocal.Z) / (sample.Pdf * float64(n))))
+		}
+	}
+
+	for _, light := range w.lit.sampled {
+		n := light.SampleCount()
+		for i := 0; i < n; i++ {
+			sample, ok := w.sampleLight(p, light, i, n)
+			if !ok {
+				continue
+			}
+
+			wiLocal := f.toLocal(sample.Wi)
+			fr := bsdf.Eval(wo, wiLocal)
+			if fr == (vector3{}) {
+				continue
+			}
+
+			visible := w.lightShadow(p, sample)
+			if visible == (vector3{}) {
+				continue
+			}
+
+			lightPdf := float64(n) * sample.Pdf
+			weight := powerHeuristic(lightPdf, bsdf.Pdf(wo, wiLocal))
+
+			c = c.Add(fr.Mult(sample.Radiance).Mult(visible).Smult(math.Abs(wiLocal.Z) * weight / lightPdf))
+		}
+	}
+
+	return c
+}
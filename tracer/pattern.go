@@ -0,0 +1,129 @@
+package tracer
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/benvardy/raytracing/lights"
+)
+
+// SamplePattern places the samples taken inside each pixel
+type SamplePattern int
+
+const (
+	// PatternStratified jitters one sample in each cell of a grid over the pixel
+	PatternStratified SamplePattern = iota
+	// PatternHalton uses the Halton sequence in bases 2 and 3, shifted randomly for each pixel
+	PatternHalton
+	// PatternSobol uses the first two dimensions of the Sobol sequence, scrambled randomly for
+	// each pixel. It is best with a power of 2 samples
+	PatternSobol
+)
+
+var samplePatternNames = []string{"stratified", "halton", "sobol"}
+
+func (p SamplePattern) String() string {
+	if int(p) < len(samplePatternNames) {
+		return samplePatternNames[p]
+	}
+	return fmt.Sprintf("SamplePattern(%d)", int(p))
+}
+
+// ParseSamplePattern returns the SamplePattern called name
+func ParseSamplePattern(name string) (SamplePattern, error) {
+	for i, n := range samplePatternNames {
+		if n == name {
+			return SamplePattern(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown sample pattern %q, want stratified, halton or sobol", name)
+}
+
+// pixelSampler hands out where in one pixel each of its samples goes. Samples are taken in
+// batches of n, which are each stratified on their own so a pixel can stop after any batch
+type pixelSampler struct {
+	pattern SamplePattern
+	n       int
+	// centre is set when the pixel only gets a single sample, which goes in the middle
+	centre bool
+	rng    *rand.Rand
+	// scramble decorrelates the Halton and Sobol points of neighbouring pixels
+	scramble [2]uint32
+}
+
+// newPixelSampler starts placing samples in a pixel in batches of n. single is set if the
+// pixel gets only one sample
+func newPixelSampler(pattern SamplePattern, n int, single bool, scramble [2]uint32, rng *rand.Rand) *pixelSampler {
+	return &pixelSampler{pattern, n, single, rng, scramble}
+}
+
+// pixelScramble returns random bits for the pixel (x, y) which stay the same from pass to pass,
+// so the Halton and Sobol points of later passes carry on from the earlier ones
+func pixelScramble(seed int64, x, y int) [2]uint32 {
+	h := splitMix64(uint64(seed) ^ splitMix64(uint64(y)<<32|uint64(uint32(x))))
+	return [2]uint32{uint32(h), uint32(h >> 32)}
+}
+
+// splitMix64 scrambles the bits of z, each bit of the input affecting all of the output
+func splitMix64(z uint64) uint64 {
+	z += 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// point returns where sample i goes, from the top left corner of the pixel
+func (s *pixelSampler) point(i int) (float64, float64) {
+	if s.centre {
+		return 0.5, 0.5
+	}
+
+	switch s.pattern {
+	case PatternHalton:
+		u := radicalInverse(2, i) + float64(s.scramble[0])/(1<<32)
+		v := radicalInverse(3, i) + float64(s.scramble[1])/(1<<32)
+		return u - math.Floor(u), v - math.Floor(v)
+	case PatternSobol:
+		return toUnit(vanDerCorput(uint32(i)) ^ s.scramble[0]), toUnit(sobol2(uint32(i)) ^ s.scramble[1])
+	}
+
+	return lights.Stratify(i%s.n, s.n, s.rng.Float64(), s.rng.Float64())
+}
+
+// radicalInverse mirrors the digits of i in base b about the decimal point
+func radicalInverse(b, i int) float64 {
+	inv := 1 / float64(b)
+	f, r := inv, 0.0
+	for ; i > 0; i /= b {
+		r += float64(i%b) * f
+		f *= inv
+	}
+	return r
+}
+
+// vanDerCorput returns the bits of i reversed, the first dimension of the Sobol sequence
+func vanDerCorput(i uint32) uint32 {
+	i = (i << 16) | (i >> 16)
+	i = ((i & 0x00ff00ff) << 8) | ((i & 0xff00ff00) >> 8)
+	i = ((i & 0x0f0f0f0f) << 4) | ((i & 0xf0f0f0f0) >> 4)
+	i = ((i & 0x33333333) << 2) | ((i & 0xcccccccc) >> 2)
+	i = ((i & 0x55555555) << 1) | ((i & 0xaaaaaaaa) >> 1)
+	return i
+}
+
+// sobol2 returns the second dimension of the Sobol sequence as 32 bits after the point
+func sobol2(i uint32) uint32 {
+	r := uint32(0)
+	for v := uint32(1 << 31); i != 0; i, v = i>>1, v^(v>>1) {
+		if i&1 != 0 {
+			r ^= v
+		}
+	}
+	return r
+}
+
+// toUnit turns 32 bits after the point into a float64 in [0, 1)
+func toUnit(bits uint32) float64 {
+	return float64(bits) / (1 << 32)
+}
@@ -2,115 +2,328 @@ package tracer
 
 import (
 	"fmt"
-	"image/color"
 	"math"
 	"math/rand"
 	"strings"
 
 	"github.com/benvardy/raytracing/core"
+	"github.com/benvardy/raytracing/lights"
+	"github.com/benvardy/raytracing/mats"
 	"github.com/benvardy/raytracing/sobjs"
 )
 
 type vector3 = core.Vector3
 
-// Trace implements a basic ray tracer
-func Trace(scene *Scene, img *core.Image, DOF, shading bool) {
+// white is the throughput of a camera ray
+var white = vector3{1, 1, 1}
 
-	maxPos := 25
-	apertureSize := scene.apertureSize
-	if !DOF {
-		maxPos = 1
-		apertureSize = 0
+// rayEpsilon is the distance secondary rays start from a surface so they don't hit it again
+const rayEpsilon = 1e-4
+
+// tileSize is the width and height in pixels of the tiles handed to workers
+const tileSize = 32
+
+// tile is a rectangle of the image rendered by a single worker
+type tile struct {
+	index          int
+	x0, y0, x1, y1 int
+}
+
+// worker holds the state used by one rendering goroutine. Each worker has its own random
+// source so no locking is needed and the results don't depend on scheduling
+type worker struct {
+	scene *Scene
+	opts  RenderOptions
+	rng   *rand.Rand
+	lit   *sceneLights
+}
+
+// tileSeed derives the seed for a tile in a pass from the render seed so that every tile gets
+// the same random sequence no matter which worker renders it, or whether the render was
+// resumed from a checkpoint
+func tileSeed(seed int64, pass, index int) int64 {
+	return seed*1000003 + int64(index)*7919 + int64(pass)*104729
+}
+
+// Trace implements a basic ray tracer, writing the radiance seen through each pixel to fb and
+// returning the number of samples each pixel took, a row at a time. It renders every pass at
+// once, see TraceProgressive
+func Trace(scene *Scene, fb *core.Framebuffer, opts RenderOptions) []int {
+	acc := NewAccumulation(scene.ScreenWidth, scene.ScreenHeight)
+	TraceProgressive(scene, acc, opts, nil)
+	acc.Resolve(fb)
+
+	return acc.Count
+}
+
+// TraceProgressive renders passes of the scene into acc, each taking opts.PixelSamples more
+// samples in every pixel that needs them, until there are opts.Passes. With adaptive sampling
+// passes go on until every pixel has converged or has opts.MaxPixelSamples instead. afterPass
+// is called after each pass if it isn't nil, so the image can be saved as it improves.
+//
+// Each pass is split into tiles which are rendered by a pool of opts.Workers goroutines, the
+// output is deterministic for a fixed opts.Seed regardless of the number of workers. Samples
+// spread into the pixels around them by opts.Filter are added up in tile order once all are
+// done. acc can hold passes loaded from a checkpoint, which are carried on from to give the
+// same image as rendering them all in one go
+func TraceProgressive(scene *Scene, acc *Accumulation, opts RenderOptions, afterPass func(acc *Accumulation)) {
+	workers, seed := opts.Workers, opts.Seed
+	if workers < 1 {
+		workers = 1
 	}
 
-	totalProg := float64(scene.ScreenHeight * scene.ScreenWidth)
-	totalHashes := 50
+	if acc.Passes == 0 {
+		acc.record(scene, opts)
+	}
 
-	for y := 0; y < scene.ScreenHeight; y++ {
-		for x := 0; x < scene.ScreenWidth; x++ {
-			// focal point
-			d := scene.GetRayToMesh(x, y).Normalize()
-			P := scene.GetEye().Add(d.Smult(scene.focalDistance))
-
-			// Changed to one to temp stop
-			var c vector3
-			if maxPos > 1 {
-				for i := 0; i < maxPos; i++ {
-					leftMod := scene.leftDirection.Smult(rand.Float64() - 0.5).Smult(apertureSize)
-					upMod := scene.upDirection.Smult(rand.Float64() - 0.5).Smult(apertureSize)
-
-					newEye := scene.GetEye().Add(leftMod).Add(upMod)
-					c = c.Add(findColor(scene, newEye, P.Subtract(newEye).Normalize(), 0, nil, shading))
-				}
-				c = c.Smult(1.0 / float64(maxPos))
-			} else {
-				c = findColor(scene, scene.eyePosition, d, 0, nil, shading)
+	scene.BuildBVH()
+	lit := newSceneLights(scene, opts)
+
+	tiles := make([]tile, 0)
+	for y := 0; y < scene.ScreenHeight; y += tileSize {
+		for x := 0; x < scene.ScreenWidth; x += tileSize {
+			x1 := int(math.Min(float64(x+tileSize), float64(scene.ScreenWidth)))
+			y1 := int(math.Min(float64(y+tileSize), float64(scene.ScreenHeight)))
+			tiles = append(tiles, tile{len(tiles), x, y, x1, y1})
+		}
+	}
+
+	pool := make([]*worker, workers)
+	for i := range pool {
+		pool[i] = &worker{scene, opts, rand.New(rand.NewSource(seed)), lit}
+	}
+
+	for pool[0].needsPass(acc) {
+		for _, img := range renderPass(pool, tiles, acc) {
+			acc.add(img)
+		}
+		acc.Passes++
+
+		if afterPass != nil {
+			afterPass(acc)
+		}
+	}
+}
+
+// centreSampled returns whether a render with opts takes its one sample in each pixel in the
+// middle, which keeps a plain render as sharp as it was before anti-aliasing
+func centreSampled(opts RenderOptions) bool {
+	return opts.PixelSamples == 1 && opts.Passes == 1 && opts.AdaptiveThreshold <= 0
+}
+
+// renderPass renders the next pass of every tile into acc with the workers in pool, returning
+// the samples spread into each tile in order
+func renderPass(pool []*worker, tiles []tile, acc *Accumulation) []*tileImage {
+	todo := make(chan tile, len(tiles))
+	for _, t := range tiles {
+		todo <- t
+	}
+	close(todo)
+
+	// Workers report the number of pixels finished after each tile
+	done := make(chan int)
+	images := make([]*tileImage, len(tiles))
+	for _, w := range pool {
+		w := w
+		go func() {
+			for t := range todo {
+				images[t.index] = w.renderTile(t, acc)
+				done <- (t.x1 - t.x0) * (t.y1 - t.y0)
 			}
+		}()
+	}
+
+	totalProg := float64(acc.Width * acc.Height)
+	totalHashes := 50
+
+	doneNow := 0
+	for range tiles {
+		doneNow += <-done
+		noHash := int(math.Ceil(float64(totalHashes) * float64(doneNow) / totalProg))
 
-			// Gamma
-			gamma := 2.2
-			c.X = math.Pow(c.X, 1/gamma)
-			c.Y = math.Pow(c.Y, 1/gamma)
-			c.Z = math.Pow(c.Z, 1/gamma)
+		fmt.Printf("[%s%s]\r", strings.Repeat("#", noHash), strings.Repeat(".", totalHashes-noHash))
+	}
+	fmt.Println()
 
-			// Convert to RGB
-			c.X = math.Min(255, c.X*255)
-			c.Y = math.Min(255, c.Y*255)
-			c.Z = math.Min(255, c.Z*255)
+	return images
+}
 
-			img.SetPixel(x, y, color.RGBA{uint8(c.X), uint8(c.Y), uint8(c.Z), 0xff})
+// renderTile takes the next pass of samples in every pixel of t that needs them and returns
+// them filtered. Each worker only changes the counts and statistics in acc of the pixels in its
+// own tile, the samples spread further so are returned to be added once the pass is done
+func (w *worker) renderTile(t tile, acc *Accumulation) *tileImage {
+	scene := w.scene
+	w.rng.Seed(tileSeed(w.opts.Seed, acc.Passes, t.index))
 
-			doneNow := float64(y*scene.ScreenWidth + x)
-			noHash := int(math.Ceil(float64(totalHashes) * doneNow / totalProg))
+	filter := w.opts.Filter
+	img := newTileImage(t, int(math.Ceil(filter.radius())), scene.ScreenWidth, scene.ScreenHeight)
 
-			fmt.Printf("[%s%s]\r", strings.Repeat("#", noHash), strings.Repeat(".", totalHashes-noHash))
+	batch := w.opts.PixelSamples
+
+	for y := t.y0; y < t.y1; y++ {
+		for x := t.x0; x < t.x1; x++ {
+			p := y*acc.Width + x
+			if !w.needsSamples(acc, p) {
+				continue
+			}
+
+			sampler := newPixelSampler(w.opts.Pattern, batch, acc.Centre, pixelScramble(w.opts.Seed, x, y), w.rng)
+
+			// The mean and sum of squared differences of the luminance are kept up to date with
+			// Welford's method
+			n, mean, m2 := acc.Count[p], acc.Mean[p], acc.M2[p]
+			for i := 0; i < batch; i++ {
+				u, v := sampler.point(n)
+				px, py := float64(x)+u, float64(y)+v
+				c := w.cameraSample(px, py)
+				img.splat(px, py, c, filter)
+				acc.Box[p] = acc.Box[p].Add(c)
+
+				n++
+				l := core.Luminance(c)
+				delta := l - mean
+				mean += delta / float64(n)
+				m2 += delta * (l - mean)
+			}
+			acc.Count[p], acc.Mean[p], acc.M2[p] = n, mean, m2
 		}
 	}
-	fmt.Println()
+
+	return img
 }
 
-func findColor(scene *Scene, s, d vector3, depth float64, prevObj sobjs.SceneObject, shading bool) vector3 {
-	// Distributed shading
-	maxTotalHit := 25
-	if !shading {
-		maxTotalHit = 1
+// needsPass reports whether any pixel in acc needs more samples
+func (w *worker) needsPass(acc *Accumulation) bool {
+	for p := range acc.Count {
+		if w.needsSamples(acc, p) {
+			return true
+		}
 	}
+	return false
+}
 
-	background := vector3{}
+// needsSamples reports whether the pixel p of acc should take another opts.PixelSamples
+func (w *worker) needsSamples(acc *Accumulation, p int) bool {
+	n, batch := acc.Count[p], w.opts.PixelSamples
+	if w.opts.AdaptiveThreshold <= 0 {
+		return n+batch <= w.opts.Passes*batch
+	}
 
-	if depth >= 3 {
-		return background
+	return n+batch <= w.opts.MaxPixelSamples && !w.converged(n, acc.Mean[p], acc.M2[p])
+}
+
+// adaptiveFloor is the least luminance the noise of a pixel is measured against, so that
+// nearly black pixels don't take every sample they can
+const adaptiveFloor = 0.1
+
+// converged reports whether a pixel with n samples whose luminance has the given mean and sum
+// of squared differences has little enough noise to stop adaptive sampling. A few samples are
+// always taken first, as the variance of fewer can't be trusted
+func (w *worker) converged(n int, mean, m2 float64) bool {
+	if n < 4 {
+		return false
 	}
 
-	var closestPos *vector3
-	var closestObject sobjs.SceneObject
+	stdErr := math.Sqrt(m2 / float64(n-1) / float64(n))
+	return stdErr <= w.opts.AdaptiveThreshold*math.Max(mean, adaptiveFloor)
+}
+
+// cameraSample returns the radiance seen through the point (x, y) on the image, averaged over
+// the lens if depth of field is on. The camera seeing nothing there counts as black
+func (w *worker) cameraSample(x, y float64) vector3 {
+	scene := w.scene
 
-	for _, o := range scene.Objects {
-		posOfIntPtr := o.IntersectWithRay(s, d)
+	origin, d, ok := scene.Camera.Ray(x, y)
+	if !ok {
+		return vector3{}
+	}
 
-		if posOfIntPtr != nil {
-			if closestObject == nil || posOfIntPtr.Subtract(s).Length() < closestPos.Subtract(s).Length() {
-				closestPos = posOfIntPtr
-				closestObject = o
+	// Each point on the lens is seen by as many rays as a pinhole camera would use
+	var lens *Lens
+	lensSamples := 1
+	if w.opts.DOF && scene.Lens != nil {
+		lens, lensSamples = scene.Lens, w.opts.LensSamples
+	}
+	rays := 1
+	if w.opts.Mode == ModePath {
+		rays = w.opts.Samples
+	}
+
+	frame := scene.Camera.Frame()
+	corner := math.Hypot(float64(scene.ScreenWidth)/float64(scene.ScreenHeight), 1)
+	px, py := imagePlane(x, y, scene.ScreenWidth, scene.ScreenHeight)
+
+	var c vector3
+	for i := 0; i < lensSamples; i++ {
+		eye, dir := origin, d
+		if lens != nil {
+			u1, u2 := lights.Stratify(i, lensSamples, w.rng.Float64(), w.rng.Float64())
+			// Rays stopped by the lens barrel add nothing, which darkens the corners
+			if eye, dir, ok = lens.Ray(frame, origin, d, px/corner, py/corner, u1, u2); !ok {
+				continue
+			}
+		}
+
+		for j := 0; j < rays; j++ {
+			if w.opts.Mode == ModePath {
+				c = c.Add(w.pathTrace(eye, dir))
+			} else {
+				c = c.Add(w.findColor(eye, dir, 0, white))
 			}
 		}
 	}
 
-	if prevObj != nil && closestObject == prevObj {
+	return c.Smult(1.0 / float64(lensSamples*rays))
+}
+
+// findColor returns the colour seen along the ray s + λd, d must be a unit vector.
+// throughput is how much the result will be scaled by on its way back to the camera, which is
+// used to decide when to end the ray with Russian roulette
+func (w *worker) findColor(s, d vector3, depth int, throughput vector3) vector3 {
+	scene := w.scene
+
+	background := vector3{}
+
+	if depth >= w.opts.MaxDepth {
 		return background
 	}
 
-	if closestObject != nil {
-		material := closestObject.GetMaterial()
+	// Rays that can only add a little are likely to be ended, the ones that survive make up for
+	// the others by being weighted up
+	survival := 1.0
+	if w.opts.RussianRoulette && depth >= w.opts.RouletteDepth {
+		survival = math.Max(0.05, math.Min(1, math.Max(throughput.X, math.Max(throughput.Y, throughput.Z))))
+		if w.rng.Float64() >= survival {
+			return background
+		}
+	}
 
-		var reflectedIntensity vector3
+	hit := scene.bvh.ClosestHit(s, d, rayEpsilon, math.Inf(1))
+
+	if hit != nil {
+		closestPos, closestObject := hit.Point, hit.Object
+		material := surfaceMaterial(hit)
+
+		// GetNormal returns the bent normal from here on
+		hit.ApplyNormalMap(material)
+
+		if material.BSDF != nil {
+			return w.findColorBSDF(hit, d, material, depth, throughput).Smult(1 / survival)
+		}
+
+		var reflectedIntensity, transmittedIntensity vector3
 		I := vector3{}
 
 		if material.Reflectivity > 0 {
-			inN := closestObject.GetNormal(*closestPos, d).Normalize()
-			mirrorDir := inN.Smult(d.Dot(inN)).Add(d).Smult(-2)
+			inN := closestObject.GetNormal(hit, s)
+			mirrorDir := reflect(d, inN).Normalize()
 
-			reflectedIntensity = findColor(scene, *closestPos, mirrorDir, depth+1, closestObject, shading)
+			weight := material.Reflectivity * (1 - material.Transmission)
+			reflectedIntensity = w.findColor(closestPos, mirrorDir, depth+1, throughput.Smult(weight))
+		}
+
+		if material.Transmission > 0 {
+			transmittedIntensity = w.findTransmitted(hit, d, material, depth, throughput.Smult(material.Transmission))
 		}
 
 		// We saw an object
@@ -119,106 +332,219 @@ func findColor(scene *Scene, s, d vector3, depth float64, prevObj sobjs.SceneObj
 		I.Y += scene.Ia.Y * material.Ka.Y
 		I.Z += scene.Ia.Z * material.Ka.Z
 
-		for _, light := range scene.Lights {
-			size := light.Size
-			if !shading {
-				size = 0
+		// Each sample of a light lights the point like a point light, with the light arriving
+		// from its direction shared between the samples
+		V := scene.GetEye().Subtract(closestPos).Normalize()
+		for _, light := range w.lit.shaded {
+			n := light.SampleCount()
+			for i := 0; i < n; i++ {
+				sample, ok := w.sampleLight(closestPos, light, i, n)
+				if !ok {
+					continue
+				}
+
+				N := closestObject.GetNormal(hit, closestPos.Add(sample.Wi))
+
+				// The fraction of each colour reaching the point from the light
+				visible := w.visibility(closestPos, light, sample)
+
+				intensity := sample.Radiance.Smult(1 / (sample.Pdf * float64(n)))
+				I = I.Add(phongLight(N, sample.Wi, V, intensity, material, visible))
 			}
+		}
 
-			totalHit := 0
+		surface := reflectedIntensity.Smult(material.Reflectivity).Add(I.Smult(1 - material.Reflectivity))
+		c := surface.Smult(1 - material.Transmission).Add(transmittedIntensity.Smult(material.Transmission))
 
-			IL := vector3{}
-			N := closestObject.GetNormal(*closestPos, light.Position).Normalize()
+		return absorb(hit, material, c.Add(emitted(hit, material))).Smult(1 / survival)
+	}
 
-			if maxTotalHit > 1 {
-				for i := 0; i < maxTotalHit; i++ {
+	if scene.Environment != nil {
+		return scene.Environment.Radiance(d).Smult(1 / survival)
+	}
 
-					leftMod := scene.leftDirection.Smult(rand.Float64() - 0.5).Smult(size)
-					lookMod := scene.lookDirection.Smult(rand.Float64() - 0.5).Smult(size)
+	// Black
+	return background
+}
 
-					LPos := light.Position.Add(leftMod).Add(lookMod)
+// findColorBSDF shades a hit on a material with a BSDF. Lights are treated like the Phong
+// model treats them, as lighting a surface facing them with their Intensity, and a single ray
+// follows any perfectly smooth part of the BSDF. Rough reflections only show the lights
+func (w *worker) findColorBSDF(hit *sobjs.Hit, d vector3, material mats.Material, depth int, throughput vector3) vector3 {
+	scene := w.scene
+	bsdf := material.BSDF
 
-					L := LPos.Subtract(*closestPos).Normalize()
+	f := shadingFrame(hit, d.Smult(-1))
+	wo := f.toLocal(d.Smult(-1))
 
-					visible := true
-					for _, o := range scene.Objects {
-						if o != closestObject {
-							shadingObjPos := o.IntersectWithRay(*closestPos, L)
+	c := scene.Ia.Mult(material.Ka).Add(emitted(hit, material))
 
-							if shadingObjPos != nil && (shadingObjPos.Subtract(*closestPos)).Dot(L) > 0 && shadingObjPos.Subtract(*closestPos).Length() < LPos.Subtract(*closestPos).Length() {
-								visible = false
-								break
-							}
-						}
-					}
+	for _, light := range w.lit.shaded {
+		n := light.SampleCount()
+		for i := 0; i < n; i++ {
+			sample, ok := w.sampleLight(hit.Point, light, i, n)
+			if !ok {
+				continue
+			}
 
-					if visible {
-						totalHit++
-					}
+			// A white Lambertian surface evaluates to 1 / π, so π makes it as bright as a Phong
+			// one with the same Kd
+			wi := f.toLocal(sample.Wi)
+			fr := bsdf.Eval(wo, wi).Smult(math.Pi * math.Abs(wi.Z) / (sample.Pdf * float64(n)))
+			if fr == (vector3{}) {
+				continue
+			}
 
-				}
+			c = c.Add(fr.Mult(sample.Radiance).Mult(w.visibility(hit.Point, light, sample)))
+		}
+	}
 
-				L := light.Position.Subtract(*closestPos).Normalize()
-
-				// Diffuse I_d = I_l * k_d * (N.L)
-				if dot := N.Dot(L); dot > 0 {
-					IL.X += light.Intensity.X * material.Kd.X * dot
-					IL.Z += light.Intensity.Z * material.Kd.Z * dot
-					IL.Y += light.Intensity.Y * material.Kd.Y * dot
-
-					// Specular
-					V := scene.GetEye().Subtract(*closestPos).Normalize()
-					R := N.Smult(2 * L.Dot(N)).Subtract(L).Normalize()
-					if R.Dot(V) > 0 {
-						dotN := math.Pow(R.Dot(V), material.Roughness)
-						IL.X += light.Intensity.X * material.Ks.X * dotN
-						IL.Y += light.Intensity.Y * material.Ks.Y * dotN
-						IL.Z += light.Intensity.Z * material.Ks.Z * dotN
-					}
-				}
+	if sample, ok := bsdf.Sample(wo, w.rng.Float64(), w.rng.Float64(), w.rng.Float64()); ok && sample.Specular && sample.Pdf > 0 {
+		weight := sample.F.Smult(math.Abs(sample.Wi.Z) / sample.Pdf)
+		dir := f.toWorld(sample.Wi).Normalize()
+		c = c.Add(w.findColor(hit.Point, dir, depth+1, throughput.Mult(weight)).Mult(weight))
+	}
 
-				I = I.Add(IL.Smult(float64(totalHit) / float64(maxTotalHit)))
+	return absorb(hit, material, c)
+}
 
-			} else {
-				L := light.Position.Subtract(*closestPos).Normalize()
-
-				visible := true
-				for _, o := range scene.Objects {
-					if o != closestObject {
-						shadingObjPos := o.IntersectWithRay(*closestPos, L)
-
-						if shadingObjPos != nil && (shadingObjPos.Subtract(*closestPos)).Dot(L) > 0 && shadingObjPos.Subtract(*closestPos).Length() < light.Position.Subtract(*closestPos).Length() {
-							visible = false
-							break
-						}
-					}
-				}
+// emitted returns the light the material gives off at hit towards the ray, which is only given
+// off from the front of the surface
+func emitted(hit *sobjs.Hit, material mats.Material) vector3 {
+	if !hit.FrontFace {
+		return vector3{}
+	}
+	return material.Emission
+}
 
-				// Diffuse I_d = I_l * k_d * (N.L)
-				if dot := N.Dot(L); visible && dot > 0 {
-					IL.X += light.Intensity.X * material.Kd.X * dot
-					IL.Z += light.Intensity.Z * material.Kd.Z * dot
-					IL.Y += light.Intensity.Y * material.Kd.Y * dot
-
-					// Specular
-					V := scene.GetEye().Subtract(*closestPos).Normalize()
-					R := N.Smult(2 * L.Dot(N)).Subtract(L).Normalize()
-					if R.Dot(V) > 0 {
-						dotN := math.Pow(R.Dot(V), material.Roughness)
-						IL.X += light.Intensity.X * material.Ks.X * dotN
-						IL.Y += light.Intensity.Y * material.Ks.Y * dotN
-						IL.Z += light.Intensity.Z * material.Ks.Z * dotN
-					}
-				}
-				I = I.Add(IL)
+// absorb applies the absorption of the inside of a transparent material to the light c leaving
+// a hit from inside it, which travelled the length of the ray to get there
+func absorb(hit *sobjs.Hit, material mats.Material, c vector3) vector3 {
+	if !hit.FrontFace && material.Transmittance() > 0 {
+		return c.Mult(beerLambert(material.Absorption, hit.T))
+	}
+	return c
+}
 
-			}
+// phongLight returns the Phong diffuse and specular light seen from the direction V from a light
+// of intensity arriving along the unit vector L, scaled by the fraction of it that is visible
+func phongLight(N, L, V, intensity vector3, material mats.Material, visible vector3) vector3 {
+	IL := vector3{}
+
+	// Diffuse I_d = I_l * k_d * (N.L)
+	if dot := N.Dot(L); visible != (vector3{}) && dot > 0 {
+		IL.X += intensity.X * material.Kd.X * dot
+		IL.Z += intensity.Z * material.Kd.Z * dot
+		IL.Y += intensity.Y * material.Kd.Y * dot
+
+		// Specular
+		R := N.Smult(2 * L.Dot(N)).Subtract(L).Normalize()
+		if R.Dot(V) > 0 {
+			dotN := math.Pow(R.Dot(V), material.Roughness)
+			IL.X += intensity.X * material.Ks.X * dotN
+			IL.Y += intensity.Y * material.Ks.Y * dotN
+			IL.Z += intensity.Z * material.Ks.Z * dotN
+		}
+	}
+
+	return IL.Mult(visible)
+}
+
+// visibility returns the fraction of each colour of the light sample that reaches p. With
+// distributed shading a point light with a Size is treated as a sphere of diameter Size and
+// the fraction of stratified points on it that can be seen is returned
+func (w *worker) visibility(p vector3, light lights.Light, sample lights.LightSample) vector3 {
+	point, ok := light.(*lights.Point)
+	if !w.opts.Shading || !ok || point.Size <= 0 {
+		return w.lightShadow(p, sample)
+	}
 
+	const samples = 25
+	sphere := lights.NewSphere(point.Position, point.Size/2, point.Intensity, samples)
+	visible := vector3{}
+	for i := 0; i < samples; i++ {
+		sample, ok := w.sampleLight(p, sphere, i, samples)
+		if !ok {
+			// Inside the light
+			return vector3{1, 1, 1}
 		}
+		visible = visible.Add(w.lightShadow(p, sample))
+	}
+
+	return visible.Smult(1.0 / samples)
+}
 
-		return reflectedIntensity.Smult(material.Reflectivity).Add(I.Smult(1 - material.Reflectivity))
+// sampleLight picks sample i of the n stratified samples taken of light as seen from p
+func (w *worker) sampleLight(p vector3, light lights.Light, i, n int) (lights.LightSample, bool) {
+	u1, u2 := lights.Stratify(i, n, w.rng.Float64(), w.rng.Float64())
+	return light.Sample(p, u1, u2)
+}
+
+// lightShadow returns the fraction of each colour of the light sample that reaches p. The ray
+// stops just short of the light so surfaces the light is set into don't shadow it
+func (w *worker) lightShadow(p vector3, sample lights.LightSample) vector3 {
+	return w.shadow(p, sample.Wi, sample.Dist-rayEpsilon)
+}
+
+// findTransmitted returns the light passing through the surface of a transparent material at hit,
+// splitting it between the reflected and refracted rays with the Fresnel equations
+func (w *worker) findTransmitted(hit *sobjs.Hit, d vector3, material mats.Material, depth int, throughput vector3) vector3 {
+	n, n1, n2 := dielectricNormal(hit, d, material)
+
+	cosI := -d.Dot(n)
+	fresnel := schlick(cosI, n1, n2)
+
+	var c vector3
+	if fresnel > 0 {
+		c = w.findColor(hit.Point, reflect(d, n).Normalize(), depth+1, throughput.Smult(fresnel)).Smult(fresnel)
 	}
 
-	// Black
-	return background
+	if fresnel < 1 {
+		if t, ok := refract(d, n, n1/n2); ok {
+			c = c.Add(w.findColor(hit.Point, t, depth+1, throughput.Smult(1-fresnel)).Smult(1 - fresnel))
+		}
+	}
+
+	return c
+}
+
+// shadow returns the fraction of each colour of light that travels from p along the unit
+// vector L for dist. Opaque objects block all of it, transparent ones let through their
+// transmission and absorb some on the way through
+func (w *worker) shadow(p, L vector3, dist float64) vector3 {
+	scene := w.scene
+	if !scene.transparent {
+		if scene.bvh.AnyHit(p, L, rayEpsilon, dist) {
+			return vector3{}
+		}
+		return vector3{1, 1, 1}
+	}
+
+	visible := vector3{1, 1, 1}
+	t := rayEpsilon
+	for {
+		hit := scene.bvh.ClosestHit(p, L, t, dist)
+		if hit == nil {
+			return visible
+		}
+
+		material := surfaceMaterial(hit)
+		transmittance := material.Transmittance()
+		if transmittance == 0 {
+			return vector3{}
+		}
+
+		// Leaving the object, so the light travelled through it since the last hit
+		if !hit.FrontFace {
+			visible = visible.Mult(beerLambert(material.Absorption, hit.T-t))
+		}
+
+		visible = visible.Smult(transmittance)
+		t = hit.T + rayEpsilon
+	}
+}
+
+// surfaceMaterial returns the material of the object at hit with its textures applied
+func surfaceMaterial(hit *sobjs.Hit) mats.Material {
+	return hit.Object.GetMaterial().At(hit.U, hit.V, hit.Point)
 }
@@ -0,0 +1,49 @@
+package tracer
+
+import (
+	"github.com/benvardy/raytracing/core"
+	"github.com/benvardy/raytracing/sobjs"
+)
+
+// frame is an orthonormal basis around a surface normal n, used to move directions in and out
+// of the local space BSDFs work in
+type frame struct {
+	t, b, n vector3
+}
+
+// newFrame returns the frame around the unit vector n
+func newFrame(n vector3) frame {
+	t, b := core.OrthonormalBasis(n)
+	return frame{t, b, n}
+}
+
+// shadingFrame returns the local frame at a hit for a ray leaving towards wo. It is built on
+// the shading normal unless that puts wo on the other side to the geometric normal, in which
+// case the geometric normal is used so light doesn't leak through the surface
+func shadingFrame(hit *sobjs.Hit, wo vector3) frame {
+	n := hit.ShadingNormal
+	if (n.Dot(wo) > 0) != (hit.Normal.Dot(wo) > 0) {
+		n = hit.Normal
+	}
+	return newFrame(n)
+}
+
+// toLocal turns the world space vector v into the frame
+func (f frame) toLocal(v vector3) vector3 {
+	return vector3{v.Dot(f.t), v.Dot(f.b), v.Dot(f.n)}
+}
+
+// toWorld turns the vector v in the frame into world space
+func (f frame) toWorld(v vector3) vector3 {
+	return f.t.Smult(v.X).Add(f.b.Smult(v.Y)).Add(f.n.Smult(v.Z))
+}
+
+// powerHeuristic weights a sample taken with probability density pdfA against another strategy
+// that could have made it with density pdfB, for multiple importance sampling
+func powerHeuristic(pdfA, pdfB float64) float64 {
+	a, b := pdfA*pdfA, pdfB*pdfB
+	if a+b == 0 {
+		return 0
+	}
+	return a / (a + b)
+}
@@ -2,69 +2,57 @@ package tracer
 
 import (
 	"github.com/benvardy/raytracing/core"
+	"github.com/benvardy/raytracing/lights"
 	"github.com/benvardy/raytracing/sobjs"
 )
 
 // Scene represents the 3D space that we are ray tracing
 type Scene struct {
-	upDirection   core.Vector3
-	leftDirection core.Vector3
-	lookDirection core.Vector3
-	eyePosition   core.Vector3
-	meshTopLeft   core.Vector3
-
-	meshDistance float64
-	pixelWidth   float64
-
-	focalDistance float64
-	apertureSize  float64
+	Camera Camera
+	// Lens blurs what is out of focus when depth of field is on, the camera is a pinhole if nil
+	Lens *Lens
 
 	ScreenWidth  int
 	ScreenHeight int
 
 	Objects []sobjs.SceneObject
-	Lights  []*core.SceneLight
+	Lights  []lights.Light
+	// Environment is seen by rays that miss everything and lights the scene, it is black if nil
+	Environment *lights.Environment
 
 	Ia core.Vector3
-}
 
-// NewScene creates a scene
-func NewScene(leftDirection, lookDirection, eyePosition core.Vector3, meshDistance, pixelWidth, focalDistance, apertureSize float64, screenWidth, screenHeight int, ia core.Vector3) *Scene {
-	upDirection := lookDirection.Cross(leftDirection).Normalize()
+	// Source identifies what the scene was built from, such as a hash of its scene file, so
+	// that a checkpoint is only carried on with the same scene
+	Source string
 
-	meshTopLeft := eyePosition.Add(lookDirection.Smult(meshDistance)).Add(leftDirection.Smult(pixelWidth * float64(screenWidth) / 2.0)).Add(upDirection.Smult(pixelWidth * float64(screenHeight) / 2.0))
+	// bvh accelerates intersections with Objects, it is built by Trace
+	bvh *sobjs.BVH
+	// transparent is set if any object lets light through, so shadow rays can't stop at the
+	// first hit
+	transparent bool
+}
 
+// NewScene creates a scene seen through camera and lens at screenWidth x screenHeight
+func NewScene(camera Camera, lens *Lens, screenWidth, screenHeight int, ia core.Vector3) *Scene {
 	return &Scene{
-		upDirection,
-		leftDirection,
-		lookDirection,
-		eyePosition,
-		meshTopLeft,
-		meshDistance,
-		pixelWidth,
-		focalDistance,
-		apertureSize,
+		camera,
+		lens,
 		screenWidth,
 		screenHeight,
 		make([]sobjs.SceneObject, 0),
-		make([]*core.SceneLight, 0),
+		make([]lights.Light, 0),
+		nil,
 		ia,
+		"",
+		nil,
+		false,
 	}
 }
 
-// GetEye returns the eyePosition
+// GetEye returns the position of the camera
 func (s *Scene) GetEye() core.Vector3 {
-	return s.eyePosition
-}
-
-// GetRayToMesh returns a normal vector from the eye to the (x, y) position on the mesh
-func (s *Scene) GetRayToMesh(x, y int) core.Vector3 {
-	down := s.upDirection.Smult(-1)
-	right := s.leftDirection.Smult(-1)
-
-	pixelPosition := s.meshTopLeft.Add(right.Smult(float64(x) * s.pixelWidth)).Add(down.Smult(float64(y) * s.pixelWidth))
-
-	return pixelPosition.Subtract(s.eyePosition).Normalize()
+	return s.Camera.Frame().Eye
 }
 
 // AddSceneObject adds an object to the scene to be rendered
@@ -72,7 +60,20 @@ func (s *Scene) AddSceneObject(obj sobjs.SceneObject) {
 	s.Objects = append(s.Objects, obj)
 }
 
+// BuildBVH builds the bounding volume hierarchy over the objects in the scene. It must be called
+// again if objects are added after tracing
+func (s *Scene) BuildBVH() {
+	s.bvh = sobjs.NewBVH(s.Objects)
+
+	s.transparent = false
+	for _, o := range s.Objects {
+		if o.GetMaterial().Transmittance() > 0 {
+			s.transparent = true
+		}
+	}
+}
+
 // AddSceneLight adds a light to the scene
-func (s *Scene) AddSceneLight(light *core.SceneLight) {
+func (s *Scene) AddSceneLight(light lights.Light) {
 	s.Lights = append(s.Lights, light)
 }
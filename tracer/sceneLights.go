@@ -0,0 +1,163 @@
+package tracer
+
+import (
+	"math"
+
+	"github.com/benvardy/raytracing/lights"
+	"github.com/benvardy/raytracing/sobjs"
+)
+
+// sceneLights are the lights of a scene sorted by how the tracers use them
+type sceneLights struct {
+	// shaded are the lights findColor samples
+	shaded []lights.Light
+
+	// direct are the lights paths can't hit, which can only be reached by sampling them, and
+	// emitters the ones that aren't objects but can be hit
+	direct   []lights.Light
+	emitters []lights.AreaLight
+	// objects are the glowing objects that can be sampled, with byObject finding them from a hit
+	objects  []*objectLight
+	byObject map[sobjs.SceneObject]*objectLight
+	// sampled are the emitters and objects, which are sampled with multiple importance sampling
+	sampled []lights.AreaLight
+}
+
+// newSceneLights sorts the lights of the scene, and finds the objects that glow. A point light
+// with a Size is a sphere of diameter Size, its radiance chosen so that from far away it is as
+// bright as a point light of the same Intensity
+func newSceneLights(scene *Scene, opts RenderOptions) *sceneLights {
+	l := &sceneLights{byObject: make(map[sobjs.SceneObject]*objectLight)}
+
+	for _, o := range scene.Objects {
+		material := o.GetMaterial()
+		sampler, ok := o.(sobjs.Sampler)
+		if !material.Emissive() || !ok || sampler.Area() <= 0 {
+			continue
+		}
+
+		light := &objectLight{o, sampler, material.Emission, material.EmissionSamples}
+		l.objects = append(l.objects, light)
+		l.byObject[o] = light
+	}
+
+	// The environment is only sampled as a light with distributed shading, otherwise it is just
+	// seen in the background and reflections
+	l.shaded = append([]lights.Light{}, scene.Lights...)
+	for _, light := range l.objects {
+		l.shaded = append(l.shaded, light)
+	}
+	if opts.Shading && scene.Environment != nil {
+		l.shaded = append(l.shaded, scene.Environment)
+	}
+
+	if scene.Environment != nil {
+		l.emitters = append(l.emitters, scene.Environment)
+	}
+	for _, light := range scene.Lights {
+		if area, ok := light.(lights.AreaLight); ok {
+			l.emitters = append(l.emitters, area)
+			continue
+		}
+
+		if point, ok := light.(*lights.Point); ok && point.Size > 0 {
+			l.emitters = append(l.emitters, lights.NewSphere(point.Position, point.Size/2, point.Intensity, 1))
+			continue
+		}
+		l.direct = append(l.direct, withFalloff(light))
+	}
+
+	l.sampled = append([]lights.AreaLight{}, l.emitters...)
+	for _, light := range l.objects {
+		l.sampled = append(l.sampled, light)
+	}
+
+	return l
+}
+
+// withFalloff returns a copy of light that falls off with the square of the distance whatever
+// its Falloff, as a physical light would, or light itself if it has no Falloff to set
+func withFalloff(light lights.Light) lights.Light {
+	switch l := light.(type) {
+	case *lights.Point:
+		falloff := *l
+		falloff.Falloff = true
+		return &falloff
+	case *lights.Spot:
+		falloff := *l
+		falloff.Falloff = true
+		return &falloff
+	case *lights.Profile:
+		falloff := *l
+		falloff.Falloff = true
+		return &falloff
+	}
+	return light
+}
+
+// objectLight is a glowing object used as a light. It gives off its material's Emission from the
+// front of its surface, and is sampled by picking points evenly over it
+type objectLight struct {
+	object   sobjs.SceneObject
+	sampler  sobjs.Sampler
+	emission vector3
+	samples  int
+}
+
+// Sample implements the lights.Light function
+func (l *objectLight) Sample(p vector3, u1, u2 float64) (lights.LightSample, bool) {
+	point, n := l.sampler.SamplePoint(u1, u2)
+	toLight := point.Subtract(p)
+	dist := toLight.Length()
+	if dist == 0 {
+		return lights.LightSample{}, false
+	}
+
+	wi := toLight.Smult(1 / dist)
+	cos := -wi.Dot(n)
+	if cos <= 0 {
+		// The back of the surface doesn't glow
+		return lights.LightSample{}, false
+	}
+
+	pdf := dist * dist / (l.sampler.Area() * cos)
+	return lights.LightSample{Wi: wi, Dist: dist, Radiance: l.emission, Pdf: pdf}, true
+}
+
+// pdfAt returns the density Sample picks the direction of a ray that hit the object at hit with
+func (l *objectLight) pdfAt(hit *sobjs.Hit, d vector3) float64 {
+	cos := -d.Dot(hit.Normal)
+	if !hit.FrontFace || cos <= 0 {
+		return 0
+	}
+	return hit.T * hit.T / (l.sampler.Area() * cos)
+}
+
+// Pdf implements the lights.AreaLight function
+func (l *objectLight) Pdf(p, wi vector3) float64 {
+	hit := l.object.Intersect(p, wi, rayEpsilon, math.Inf(1))
+	if hit == nil {
+		return 0
+	}
+	return l.pdfAt(hit, wi)
+}
+
+// Intersect implements the lights.AreaLight function
+func (l *objectLight) Intersect(s, d vector3) (float64, vector3, bool) {
+	hit := l.object.Intersect(s, d, rayEpsilon, math.Inf(1))
+	if hit == nil {
+		return 0, vector3{}, false
+	}
+	if !hit.FrontFace {
+		return hit.T, vector3{}, true
+	}
+	return hit.T, l.emission, true
+}
+
+// SampleCount implements the lights.Light function
+func (l *objectLight) SampleCount() int {
+	if l.samples < 1 {
+		return 1
+	}
+	return l.samples
+}